@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"internal/database"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AdminUser is the view of an account shown to admins.
+type AdminUser struct {
+	ID                    int                  `json:"id"`
+	EmailID               string               `json:"email"`
+	Subscription          bool                 `json:"is_chirpy_red"`
+	Role                  string               `json:"role"`
+	Suspension            *database.Suspension `json:"suspension,omitempty"`
+	PasswordResetRequired bool                 `json:"password_reset_required"`
+}
+
+func newAdminUser(user database.User) AdminUser {
+	return AdminUser{
+		ID:                    user.ID,
+		EmailID:               user.EmailID,
+		Subscription:          user.Subscription,
+		Role:                  user.EffectiveRole(),
+		Suspension:            user.Suspension,
+		PasswordResetRequired: user.PasswordResetRequired,
+	}
+}
+
+// handlerAdminUsersRetrieve lists accounts, optionally filtered by ?q=, which
+// matches a user ID exactly or any part of an email.
+func (cfg *apiConfig) handlerAdminUsersRetrieve(w http.ResponseWriter, r *http.Request) {
+	users, err := cfg.DB.SearchUsers(r.URL.Query().Get("q"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch users")
+		return
+	}
+
+	adminUsers := make([]AdminUser, 0, len(users))
+	for _, user := range users {
+		adminUsers = append(adminUsers, newAdminUser(user))
+	}
+
+	respondWithJSON(w, http.StatusOK, adminUsers)
+}
+
+func (cfg *apiConfig) handlerAdminUserRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		AdminUser
+		ChirpCount     int                 `json:"chirp_count"`
+		FollowerCount  int                 `json:"follower_count"`
+		FollowingCount int                 `json:"following_count"`
+		Sessions       []Session           `json:"sessions"`
+		Identities     []database.Identity `json:"identities"`
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	user, err := cfg.DB.GetUserID(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	chirpCount, err := cfg.DB.GetChirpCount(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count chirps")
+		return
+	}
+	followers, following, err := cfg.DB.GetFollowCounts(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count follows")
+		return
+	}
+	sessions, err := cfg.DB.GetSessions(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch sessions")
+		return
+	}
+	identities, err := cfg.DB.GetUserIdentities(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch identities")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		AdminUser:      newAdminUser(user),
+		ChirpCount:     chirpCount,
+		FollowerCount:  followers,
+		FollowingCount: following,
+		Sessions:       newSessions(sessions, ""),
+		Identities:     identities,
+	})
+}
+
+// handlerAdminPasswordReset replaces the user's password with a random one,
+// which is returned once so the admin can pass it on. The user is logged out
+// everywhere and asked to choose a new password the next time they log in.
+func (cfg *apiConfig) handlerAdminPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		AdminUser
+		TemporaryPassword string `json:"temporary_password"`
+	}
+
+	adminID, userID, ok := cfg.adminUserParams(w, r)
+	if !ok {
+		return
+	}
+
+	buf := make([]byte, 12)
+	_, err := rand.Read(buf)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate password")
+		return
+	}
+	password := base64.RawURLEncoding.EncodeToString(buf)
+	hashPass, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+		return
+	}
+
+	user, err := cfg.DB.ResetPassword(userID, hashPass)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password")
+		return
+	}
+	cfg.recordAudit(r, adminID, "user.password_reset", userTarget(userID), nil)
+
+	respondWithJSON(w, http.StatusOK, response{
+		AdminUser:         newAdminUser(user),
+		TemporaryPassword: password,
+	})
+}
+
+func (cfg *apiConfig) handlerAdminEmailUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	adminID, userID, ok := cfg.adminUserParams(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+	email := strings.TrimSpace(params.Email)
+	if !strings.Contains(email, "@") {
+		respondWithError(w, http.StatusBadRequest, "Invalid email")
+		return
+	}
+
+	old, err := cfg.DB.GetUserID(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	user, err := cfg.DB.SetUserEmail(userID, email)
+	if errors.Is(err, database.ErrEmailTaken) {
+		respondWithError(w, http.StatusConflict, "Email is already in use")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update email")
+		return
+	}
+	cfg.recordAudit(r, adminID, "user.email_change", userTarget(userID), map[string]string{
+		"from": old.EmailID,
+		"to":   user.EmailID,
+	})
+
+	respondWithJSON(w, http.StatusOK, newAdminUser(user))
+}
+
+func (cfg *apiConfig) handlerAdminSubscriptionUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Subscription bool `json:"is_chirpy_red"`
+	}
+
+	adminID, userID, ok := cfg.adminUserParams(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+
+	user, err := cfg.DB.SetSubscription(userID, params.Subscription)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update subscription")
+		return
+	}
+	cfg.recordAudit(r, adminID, "user.subscription_change", userTarget(userID), map[string]string{
+		"is_chirpy_red": strconv.FormatBool(params.Subscription),
+	})
+
+	respondWithJSON(w, http.StatusOK, newAdminUser(user))
+}
+
+func (cfg *apiConfig) handlerAdminUserDelete(w http.ResponseWriter, r *http.Request) {
+	adminID, userID, ok := cfg.adminUserParams(w, r)
+	if !ok {
+		return
+	}
+	if userID == adminID {
+		respondWithError(w, http.StatusBadRequest, "You can't delete your own account here")
+		return
+	}
+
+	user, err := cfg.DB.GetUserID(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	chirps, affected, err := cfg.DB.DeleteUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete user")
+		return
+	}
+	cfg.recordAudit(r, adminID, "user.delete", userTarget(userID), map[string]string{
+		"email":  user.EmailID,
+		"chirps": strconv.Itoa(len(chirps)),
+	})
+
+	// The author's followers are gone from the database, so rebuild every
+	// affected inbox instead of removing chirps one by one.
+	for _, id := range affected {
+		cfg.Timelines.FollowsChanged(id)
+	}
+	err = cfg.refreshTrends()
+	if err != nil {
+		log.Printf("Couldn't compute trends: %s", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminUserParams reads the acting admin and the target user ID from r,
+// writing an error response and returning false if either is invalid.
+func (cfg *apiConfig) adminUserParams(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	adminID := requestPrincipal(r).User.ID
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return 0, 0, false
+	}
+
+	return adminID, userID, true
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"internal/audit"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+func userTarget(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+// recordAudit appends an event caused by r to the audit log. Failing to
+// record is logged rather than undoing the action.
+func (cfg *apiConfig) recordAudit(r *http.Request, actorID int, action, target string, details map[string]string) {
+	_, err := cfg.Audit.Record(audit.Entry{
+		ActorID:   actorID,
+		Action:    action,
+		Target:    target,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Details:   details,
+	})
+	if err != nil {
+		log.Printf("Couldn't record %s by %d on %s: %s", action, actorID, target, err)
+	}
+}
+
+// clientIP returns the address r came from. Proxy headers are ignored since
+// they can be set by the client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// auditFilter reads ?actor=, ?action=, ?target=, ?since= and ?until= from r.
+// Times are RFC 3339.
+func auditFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+	}
+	var err error
+	if actor := query.Get("actor"); actor != "" {
+		filter.ActorID, err = strconv.Atoi(actor)
+		if err != nil {
+			return audit.Filter{}, errors.New("Invalid actor")
+		}
+	}
+	if since := query.Get("since"); since != "" {
+		filter.Since, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			return audit.Filter{}, errors.New("Invalid since time")
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		filter.Until, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			return audit.Filter{}, errors.New("Invalid until time")
+		}
+	}
+	return filter, nil
+}
+
+func (cfg *apiConfig) handlerAuditRetrieve(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := defaultAuditLimit
+	if param := r.URL.Query().Get("limit"); param != "" {
+		limit, err = strconv.Atoi(param)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+	}
+
+	entries, err := cfg.Audit.Query(filter, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read audit log")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, entries)
+}
+
+// handlerAuditExport downloads every matching entry, oldest first, as JSON
+// lines (the default, hashes included so the export can be verified) or as
+// CSV with ?format=csv.
+func (cfg *apiConfig) handlerAuditExport(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "jsonl" && format != "csv" {
+		respondWithError(w, http.StatusBadRequest, "Invalid format")
+		return
+	}
+
+	entries, err := cfg.Audit.Query(filter, 0)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read audit log")
+		return
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+		out := csv.NewWriter(w)
+		out.Write([]string{"seq", "time", "actor_id", "action", "target", "ip", "user_agent", "details", "prev_hash", "hash"})
+		for _, entry := range entries {
+			details := ""
+			if len(entry.Details) > 0 {
+				dat, _ := json.Marshal(entry.Details)
+				details = string(dat)
+			}
+			out.Write([]string{
+				strconv.Itoa(entry.Seq),
+				entry.Time.Format(time.RFC3339Nano),
+				strconv.Itoa(entry.ActorID),
+				entry.Action,
+				entry.Target,
+				entry.IP,
+				entry.UserAgent,
+				details,
+				entry.PrevHash,
+				entry.Hash,
+			})
+		}
+		out.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		encoder.Encode(entry)
+	}
+}
+
+func (cfg *apiConfig) handlerAuditVerify(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Valid   bool   `json:"valid"`
+		Entries int    `json:"entries"`
+		Error   string `json:"error,omitempty"`
+	}
+
+	count, err := cfg.Audit.Verify()
+	if err != nil && !errors.Is(err, audit.ErrTampered) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read audit log")
+		return
+	}
+	resp := response{
+		Valid:   err == nil,
+		Entries: count,
+	}
+	if err != nil {
+		resp.Error = strings.TrimPrefix(err.Error(), audit.ErrTampered.Error()+": ")
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
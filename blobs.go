@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"internal/blobstore"
+)
+
+// newBlobStore builds the blob store named by kind from the environment.
+// An empty kind means the local filesystem.
+func newBlobStore(kind string) (blobstore.Store, error) {
+	switch kind {
+	case "", "local":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "media"
+		}
+		return blobstore.NewLocal(dir)
+	case "s3":
+		return blobstore.NewS3(blobstore.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle: os.Getenv("S3_PATH_STYLE") == "true",
+		})
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", kind)
+	}
+}
+
+// runMigrateBlobs implements "chirpy migrate-blobs", which copies or moves
+// every blob from one configured store to another.
+func runMigrateBlobs(args []string) error {
+	flags := flag.NewFlagSet("migrate-blobs", flag.ContinueOnError)
+	from := flags.String("from", "local", "store to copy blobs from (local or s3)")
+	to := flags.String("to", "s3", "store to copy blobs to (local or s3)")
+	prefix := flags.String("prefix", "", "only copy keys starting with this prefix")
+	remove := flags.Bool("delete", false, "delete each blob from the source once copied")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *from == *to {
+		return fmt.Errorf("-from and -to must name different stores")
+	}
+
+	src, err := newBlobStore(*from)
+	if err != nil {
+		return err
+	}
+	dst, err := newBlobStore(*to)
+	if err != nil {
+		return err
+	}
+
+	transfer := blobstore.Copy
+	if *remove {
+		transfer = blobstore.Move
+	}
+	copied, err := transfer(context.Background(), dst, src, *prefix)
+	log.Printf("Migrated %d blobs from %s to %s", copied, *from, *to)
+	return err
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"internal/database"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// visibleChirps drops the chirps viewerID has blocked, been blocked by, or
+// muted the authors of, chirps hidden by their author's suspension, and
+// chirps hidden by moderators unless viewerID wrote them.
+func (cfg *apiConfig) visibleChirps(viewerID int, dbChirps []database.Chirp) ([]database.Chirp, error) {
+	hidden, err := cfg.DB.GetHiddenAuthors()
+	if err != nil {
+		return nil, err
+	}
+	if viewerID != 0 {
+		restricted, err := cfg.DB.GetHiddenUsers(viewerID)
+		if err != nil {
+			return nil, err
+		}
+		for id := range restricted {
+			hidden[id] = struct{}{}
+		}
+	}
+
+	visible := []database.Chirp{}
+	for _, chirp := range dbChirps {
+		if chirp.Hidden && chirp.UserID != viewerID {
+			continue
+		}
+		if _, ok := hidden[chirp.UserID]; !ok {
+			visible = append(visible, chirp)
+		}
+	}
+	return visible, nil
+}
+
+func (cfg *apiConfig) handlerBlockCreate(w http.ResponseWriter, r *http.Request) {
+	userID, targetID, ok := cfg.relationshipParams(w, r)
+	if !ok {
+		return
+	}
+
+	block, err := cfg.DB.Block(userID, targetID)
+	if errors.Is(err, database.ErrSelfRestrict) {
+		respondWithError(w, http.StatusBadRequest, "You can't block yourself")
+		return
+	}
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't block user")
+		return
+	}
+	// Blocking removes follows in both directions.
+	cfg.Timelines.FollowsChanged(userID)
+	cfg.Timelines.FollowsChanged(targetID)
+
+	respondWithJSON(w, http.StatusCreated, block)
+}
+
+func (cfg *apiConfig) handlerBlockDelete(w http.ResponseWriter, r *http.Request) {
+	userID, targetID, ok := cfg.relationshipParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.DB.Unblock(userID, targetID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unblock user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerMuteCreate(w http.ResponseWriter, r *http.Request) {
+	userID, targetID, ok := cfg.relationshipParams(w, r)
+	if !ok {
+		return
+	}
+
+	mute, err := cfg.DB.Mute(userID, targetID)
+	if errors.Is(err, database.ErrSelfRestrict) {
+		respondWithError(w, http.StatusBadRequest, "You can't mute yourself")
+		return
+	}
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't mute user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, mute)
+}
+
+func (cfg *apiConfig) handlerMuteDelete(w http.ResponseWriter, r *http.Request) {
+	userID, targetID, ok := cfg.relationshipParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.DB.Unmute(userID, targetID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unmute user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerBlocksRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).User.ID
+
+	blocks, err := cfg.DB.GetBlocks(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch blocks")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, blocks)
+}
+
+func (cfg *apiConfig) handlerMutesRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).User.ID
+
+	mutes, err := cfg.DB.GetMutes(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch mutes")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, mutes)
+}
+
+// relationshipParams reads the caller and the user in the URL for the block
+// and mute endpoints, responding with an error if either is missing.
+func (cfg *apiConfig) relationshipParams(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	userID := requestPrincipal(r).User.ID
+
+	targetID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return 0, 0, false
+	}
+
+	return userID, targetID, true
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"internal/database"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// extractEntities finds the #hashtags and @mentions in a chirp by authorID.
+// Mentions are matched against the local part of users' email addresses;
+// ones that don't resolve to exactly one user, or that name someone the
+// author has a block with, are left as plain text.
+func (cfg *apiConfig) extractEntities(authorID int, body string) ([]database.Hashtag, []database.Mention, error) {
+	hashtags := []database.Hashtag{}
+	mentions := []database.Mention{}
+
+	runes := []rune(body)
+	for i := 0; i < len(runes); i++ {
+		sigil := runes[i]
+		if sigil != '#' && sigil != '@' {
+			continue
+		}
+		// A sigil only starts an entity at a word boundary, so "a@b.com" and
+		// "c#" are left alone.
+		if i > 0 && isEntityRune(runes[i-1], sigil) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && isEntityRune(runes[end], sigil) {
+			end++
+		}
+		// Handles may contain dots, but not as trailing punctuation.
+		for end > i+1 && runes[end-1] == '.' {
+			end--
+		}
+		if end == i+1 {
+			continue
+		}
+		name := string(runes[i+1 : end])
+
+		if sigil == '#' {
+			hashtags = append(hashtags, database.Hashtag{
+				Tag:   strings.ToLower(name),
+				Start: i,
+				End:   end,
+			})
+		} else {
+			user, err := cfg.DB.GetUserByHandle(name)
+			if errors.Is(err, database.ErrNotExist) || errors.Is(err, database.ErrAmbiguousHandle) {
+				i = end - 1
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			blocked, err := cfg.DB.IsBlocked(authorID, user.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			if blocked {
+				i = end - 1
+				continue
+			}
+			mentions = append(mentions, database.Mention{
+				UserID: user.ID,
+				Handle: name,
+				Start:  i,
+				End:    end,
+			})
+		}
+		i = end - 1
+	}
+
+	return hashtags, mentions, nil
+}
+
+func isEntityRune(r rune, sigil rune) bool {
+	if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+		return true
+	}
+	// Email local parts allow a few more characters than hashtags do.
+	return sigil == '@' && (r == '.' || r == '-' || r == '+')
+}
+
+func (cfg *apiConfig) handlerTagChirpsRetrieve(w http.ResponseWriter, r *http.Request) {
+	tag := strings.ToLower(strings.TrimPrefix(chi.URLParam(r, "tag"), "#"))
+	if tag == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid tag")
+		return
+	}
+
+	dbChirps, err := cfg.DB.GetChirpsByTag(tag)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch Chirps")
+		return
+	}
+
+	cfg.respondWithChirps(w, r, dbChirps)
+}
+
+func (cfg *apiConfig) handlerMentionsRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	_, err = cfg.DB.GetUserID(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	dbChirps, err := cfg.DB.GetChirpsMentioning(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch Chirps")
+		return
+	}
+
+	cfg.respondWithChirps(w, r, dbChirps)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"internal/database"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	filterKindKeyword = "keyword"
+	filterKindPhrase  = "phrase"
+	filterKindHashtag = "hashtag"
+
+	filterActionHide = "hide"
+	filterActionBlur = "blur"
+
+	maxFilterLength = 100
+)
+
+// FilterAnnotation explains why a chirp was filtered for the caller. Hidden
+// chirps have their content removed; blurred ones keep it for the client to
+// reveal on request.
+type FilterAnnotation struct {
+	Action  string   `json:"action"`
+	Matched []string `json:"matched"`
+}
+
+// applyContentFilters annotates chirps that match viewerID's active muted
+// words. The viewer's own chirps are never filtered.
+func (cfg *apiConfig) applyContentFilters(viewerID int, chirps []Chirp) error {
+	if viewerID == 0 {
+		return nil
+	}
+	filters, err := cfg.DB.GetContentFilters(viewerID)
+	if err != nil {
+		return err
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+
+	for i := range chirps {
+		if chirps[i].AuthID == viewerID {
+			continue
+		}
+		annotation := matchFilters(chirps[i], filters)
+		if annotation == nil {
+			continue
+		}
+		if annotation.Action == filterActionHide {
+			chirps[i].Body = ""
+			chirps[i].Hashtags = []database.Hashtag{}
+			chirps[i].Mentions = []database.Mention{}
+			chirps[i].Media = []Attachment{}
+		}
+		chirps[i].Filtered = annotation
+	}
+	return nil
+}
+
+// matchFilters returns the annotation for chirp, or nil if no filter matches.
+// Hiding wins over blurring when filters of both kinds match.
+func matchFilters(chirp Chirp, filters []database.ContentFilter) *FilterAnnotation {
+	words := filterWords(chirp.Body)
+	joined := " " + strings.Join(words, " ") + " "
+	tags := map[string]struct{}{}
+	for _, hashtag := range chirp.Hashtags {
+		tags[hashtag.Tag] = struct{}{}
+	}
+
+	var annotation *FilterAnnotation
+	for _, filter := range filters {
+		matched := false
+		switch filter.Kind {
+		case filterKindKeyword, filterKindPhrase:
+			// Comparing on word boundaries keeps "cat" from muting "concat".
+			matched = strings.Contains(joined, " "+strings.Join(filterWords(filter.Value), " ")+" ")
+		case filterKindHashtag:
+			_, matched = tags[filter.Value]
+		}
+		if !matched {
+			continue
+		}
+
+		if annotation == nil {
+			annotation = &FilterAnnotation{Action: filter.Action}
+		}
+		if filter.Action == filterActionHide {
+			annotation.Action = filterActionHide
+		}
+		annotation.Matched = append(annotation.Matched, filter.Value)
+	}
+	return annotation
+}
+
+// filterWords lower-cases s and splits it into words, dropping punctuation.
+func filterWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+func (cfg *apiConfig) handlerFiltersCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Kind             string `json:"kind"`
+		Value            string `json:"value"`
+		Action           string `json:"action"`
+		ExpiresInSeconds int    `json:"expires_in_seconds"`
+	}
+
+	userID := requestPrincipal(r).User.ID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+
+	filter, err := newContentFilter(userID, params.Kind, params.Value, params.Action, params.ExpiresInSeconds)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter, err = cfg.DB.CreateContentFilter(filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save filter")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, filter)
+}
+
+// newContentFilter validates and normalises a filter submitted by userID.
+func newContentFilter(userID int, kind, value, action string, expiresInSeconds int) (database.ContentFilter, error) {
+	if action == "" {
+		action = filterActionHide
+	}
+	if action != filterActionHide && action != filterActionBlur {
+		return database.ContentFilter{}, errors.New("Action must be hide or blur")
+	}
+
+	value = strings.TrimSpace(value)
+	switch kind {
+	case filterKindKeyword:
+		if len(filterWords(value)) != 1 {
+			return database.ContentFilter{}, errors.New("Keywords must be a single word")
+		}
+		value = strings.ToLower(value)
+	case filterKindPhrase:
+		if len(filterWords(value)) == 0 {
+			return database.ContentFilter{}, errors.New("Phrase must contain a word")
+		}
+		value = strings.ToLower(value)
+	case filterKindHashtag:
+		value = strings.ToLower(strings.TrimPrefix(value, "#"))
+		if value == "" {
+			return database.ContentFilter{}, errors.New("Invalid hashtag")
+		}
+	default:
+		return database.ContentFilter{}, errors.New("Kind must be keyword, phrase or hashtag")
+	}
+	if len(value) > maxFilterLength {
+		return database.ContentFilter{}, errors.New("Filter is too long")
+	}
+
+	filter := database.ContentFilter{
+		UserID: userID,
+		Kind:   kind,
+		Value:  value,
+		Action: action,
+	}
+	if expiresInSeconds < 0 {
+		return database.ContentFilter{}, errors.New("Invalid expiry")
+	}
+	if expiresInSeconds > 0 {
+		expiresAt := time.Now().UTC().Add(time.Duration(expiresInSeconds) * time.Second)
+		filter.ExpiresAt = &expiresAt
+	}
+	return filter, nil
+}
+
+func (cfg *apiConfig) handlerFiltersRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).User.ID
+
+	filters, err := cfg.DB.GetContentFilters(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch filters")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, filters)
+}
+
+func (cfg *apiConfig) handlerFiltersDelete(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).User.ID
+
+	filterID, err := strconv.Atoi(chi.URLParam(r, "filterID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid filter ID")
+		return
+	}
+
+	err = cfg.DB.DeleteContentFilter(userID, filterID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Filter not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete filter")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
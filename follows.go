@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"internal/database"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultTimelineLimit = 20
+	maxTimelineLimit     = 100
+)
+
+type FollowUser struct {
+	User
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+func (cfg *apiConfig) handlerFollowCreate(w http.ResponseWriter, r *http.Request) {
+	followerID := requestPrincipal(r).User.ID
+
+	followeeID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	follow, err := cfg.DB.Follow(followerID, followeeID)
+	if errors.Is(err, database.ErrSelfFollow) {
+		respondWithError(w, http.StatusBadRequest, "You can't follow yourself")
+		return
+	}
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		respondWithError(w, http.StatusForbidden, "You can't follow this user")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user")
+		return
+	}
+	cfg.Timelines.FollowsChanged(followerID)
+
+	respondWithJSON(w, http.StatusCreated, follow)
+}
+
+func (cfg *apiConfig) handlerFollowDelete(w http.ResponseWriter, r *http.Request) {
+	followerID := requestPrincipal(r).User.ID
+
+	followeeID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	err = cfg.DB.Unfollow(followerID, followeeID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user")
+		return
+	}
+	cfg.Timelines.FollowsChanged(followerID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerFollowersRetrieve(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithFollowList(w, r, cfg.DB.GetFollowers, func(f database.Follow) int {
+		return f.FollowerID
+	})
+}
+
+func (cfg *apiConfig) handlerFollowingRetrieve(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithFollowList(w, r, cfg.DB.GetFollowing, func(f database.Follow) int {
+		return f.FolloweeID
+	})
+}
+
+// respondWithFollowList renders one side of the follow graph for the user in
+// the URL. other picks the user on the far end of each edge.
+func (cfg *apiConfig) respondWithFollowList(w http.ResponseWriter, r *http.Request, list func(int) ([]database.Follow, error), other func(database.Follow) int) {
+	type response struct {
+		Count int          `json:"count"`
+		Users []FollowUser `json:"users"`
+	}
+
+	viewerID := requestUserID(r)
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	_, err = cfg.DB.GetUserID(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	follows, err := list(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch follows")
+		return
+	}
+
+	ids := []int{}
+	for _, follow := range follows {
+		ids = append(ids, other(follow))
+	}
+	dbUsers, err := cfg.DB.GetUsersByIDs(ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch follows")
+		return
+	}
+
+	hidden := map[int]struct{}{}
+	if viewerID != 0 {
+		hidden, err = cfg.DB.GetBlockedUsers(viewerID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't fetch follows")
+			return
+		}
+	}
+
+	users := []FollowUser{}
+	for _, follow := range follows {
+		dbUser, ok := dbUsers[other(follow)]
+		if !ok {
+			continue
+		}
+		if _, ok := hidden[dbUser.ID]; ok {
+			continue
+		}
+		users = append(users, FollowUser{
+			User: User{
+				ID:           dbUser.ID,
+				EmailID:      dbUser.EmailID,
+				Subscription: dbUser.Subscription,
+			},
+			FollowedAt: follow.CreatedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Count: len(users),
+		Users: users,
+	})
+}
+
+func (cfg *apiConfig) handlerTimelineRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Chirps     []Chirp `json:"chirps"`
+		NextCursor string  `json:"next_cursor,omitempty"`
+	}
+
+	userID := requestPrincipal(r).User.ID
+
+	beforeID, limit, err := parsePage(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ids, err := cfg.Timelines.Timeline(userID, beforeID, limit+1)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch timeline")
+		return
+	}
+	dbChirps, err := cfg.DB.GetChirpsByIDs(ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch timeline")
+		return
+	}
+
+	// One extra chirp was fetched to learn whether there is another page.
+	nextCursor := ""
+	if len(dbChirps) > limit {
+		dbChirps = dbChirps[:limit]
+		nextCursor = strconv.Itoa(dbChirps[limit-1].ID)
+	}
+
+	// Mutes and blocks are applied after paging so cursors stay stable.
+	dbChirps, err = cfg.visibleChirps(userID, dbChirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch timeline")
+		return
+	}
+
+	chirps, err := cfg.newChirps(dbChirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch timeline")
+		return
+	}
+	err = cfg.applyContentFilters(userID, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch timeline")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Chirps:     chirps,
+		NextCursor: nextCursor,
+	})
+}
+
+// parsePage reads the "cursor" and "limit" query parameters used by paginated
+// chirp lists. The cursor is the ID of the last chirp on the previous page.
+func parsePage(r *http.Request) (beforeID, limit int, err error) {
+	limit = defaultTimelineLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxTimelineLimit {
+			return 0, 0, errors.New("Invalid limit")
+		}
+	}
+	if s := r.URL.Query().Get("cursor"); s != "" {
+		beforeID, err = strconv.Atoi(s)
+		if err != nil || beforeID < 1 {
+			return 0, 0, errors.New("Invalid cursor")
+		}
+	}
+	return beforeID, limit, nil
+}
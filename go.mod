@@ -12,8 +12,36 @@ require github.com/joho/godotenv v1.5.1
 
 require github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
 
+require golang.org/x/text v0.14.0 // indirect
+
+require github.com/tetratelabs/wazero v1.8.2 // indirect
+
 replace internal/database => ./internal/database
 
 require internal/auth v1.0.0
 
 replace internal/auth => ./internal/auth
+
+require internal/media v1.0.0
+
+replace internal/media => ./internal/media
+
+require internal/blobstore v1.0.0
+
+replace internal/blobstore => ./internal/blobstore
+
+require internal/timeline v1.0.0
+
+replace internal/timeline => ./internal/timeline
+
+require internal/moderation v1.0.0
+
+replace internal/moderation => ./internal/moderation
+
+require internal/plugins v1.0.0
+
+replace internal/plugins => ./internal/plugins
+
+require internal/audit v1.0.0
+
+replace internal/audit => ./internal/audit
@@ -0,0 +1,391 @@
+// Package audit keeps an append-only log of security-relevant events.
+//
+// Entries are stored one JSON object per line. Each entry carries the hash of
+// the entry before it and a hash over its own contents and that previous
+// hash, so editing, removing or reordering any entry breaks the chain from
+// that point on. With a key the hashes are HMACs, so the chain can't be
+// recomputed by someone who can only write the file.
+//
+// A chain alone can't show that entries were cut from the end, so the
+// sequence number and hash of the last entry are also kept in an anchor file
+// beside the log, signed with the key when there is one.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Entry is one event. Target names what the action was applied to, such as
+// "user:12".
+type Entry struct {
+	Seq       int               `json:"seq"`
+	Time      time.Time         `json:"time"`
+	ActorID   int               `json:"actor_id"`
+	Action    string            `json:"action"`
+	Target    string            `json:"target,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash"`
+}
+
+// Filter selects entries. Zero fields match everything.
+type Filter struct {
+	ActorID int
+	Action  string
+	Target  string
+	Since   time.Time
+	Until   time.Time
+}
+
+func (f Filter) Match(entry Entry) bool {
+	if f.ActorID != 0 && entry.ActorID != f.ActorID {
+		return false
+	}
+	if f.Action != "" && entry.Action != f.Action {
+		return false
+	}
+	if f.Target != "" && entry.Target != f.Target {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// ErrTampered is returned by Verify when the chain is broken.
+var ErrTampered = errors.New("audit log has been tampered with")
+
+// anchor records the last entry written, so Verify can tell when entries
+// have been removed from the end of the log.
+type anchor struct {
+	Seq  int    `json:"seq"`
+	Hash string `json:"hash"`
+	MAC  string `json:"mac,omitempty"`
+}
+
+type Log struct {
+	path       string
+	anchorPath string
+	key        []byte
+
+	mu       sync.Mutex
+	lastSeq  int
+	lastHash string
+	// size is the file size after the last entry this Log saw. If the file
+	// has grown since, another process appended to it and the tail is read
+	// again before chaining onto it.
+	size int64
+	// anchored is set while the anchor agrees with the log. Otherwise the
+	// anchor is left as it is, so Verify keeps reporting the mismatch.
+	anchored bool
+}
+
+// Open opens the log at path, creating it if needed. The anchor is kept at
+// path with ".anchor" appended. An empty key hashes entries with plain
+// SHA-256 and leaves the anchor unsigned.
+func Open(path string, key []byte) (*Log, error) {
+	l := &Log{
+		path:       path,
+		anchorPath: path + ".anchor",
+		key:        key,
+	}
+	err := l.readTail()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) readTail() error {
+	a, err := l.readAnchor()
+	badAnchor := errors.Is(err, ErrTampered)
+	if err != nil && !badAnchor {
+		return err
+	}
+
+	l.lastSeq = 0
+	l.lastHash = ""
+	l.size = 0
+	matched := false
+	err = l.each(func(entry Entry) error {
+		l.lastSeq = entry.Seq
+		l.lastHash = entry.Hash
+		if a != nil && entry.Seq == a.Seq && entry.Hash == a.Hash {
+			matched = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	l.anchored = matched || (a == nil && !badAnchor && l.lastSeq == 0)
+	info, err := os.Stat(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	l.size = info.Size()
+	return nil
+}
+
+// Record appends entry, filling in its sequence number, time and hashes.
+func (l *Log) Record(entry Entry) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Entry{}, err
+	}
+	if info.Size() != l.size {
+		err = l.readTail()
+		if err != nil {
+			return Entry{}, err
+		}
+	}
+
+	entry.Seq = l.lastSeq + 1
+	entry.Time = time.Now().UTC()
+	entry.PrevHash = l.lastHash
+	entry.Hash, err = l.sum(entry)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return Entry{}, err
+	}
+	line = append(line, '\n')
+	_, err = f.Write(line)
+	if err != nil {
+		return Entry{}, err
+	}
+	err = f.Sync()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	l.lastSeq = entry.Seq
+	l.lastHash = entry.Hash
+	l.size = info.Size() + int64(len(line))
+
+	if l.anchored {
+		err = l.writeAnchor(entry)
+		if err != nil {
+			return Entry{}, err
+		}
+	}
+	return entry, nil
+}
+
+// Query returns up to limit entries matching filter, newest first. A limit of
+// 0 returns every match.
+func (l *Log) Query(filter Filter, limit int) ([]Entry, error) {
+	entries := []Entry{}
+	err := l.each(func(entry Entry) error {
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// Verify walks the whole log and returns the number of entries checked. If
+// the chain is broken it returns the sequence number of the first bad entry
+// and an error wrapping ErrTampered. The log must also still hold the entry
+// named by the anchor.
+func (l *Log) Verify() (int, error) {
+	a, err := l.readAnchor()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	prevHash := ""
+	err = l.each(func(entry Entry) error {
+		count++
+		if entry.Seq != count || entry.PrevHash != prevHash {
+			return fmt.Errorf("%w: entry %d is out of sequence", ErrTampered, count)
+		}
+		sum, err := l.sum(entry)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal([]byte(sum), []byte(entry.Hash)) {
+			return fmt.Errorf("%w: entry %d doesn't match its hash", ErrTampered, count)
+		}
+		if a != nil && entry.Seq == a.Seq && entry.Hash != a.Hash {
+			return fmt.Errorf("%w: entry %d doesn't match the anchor", ErrTampered, count)
+		}
+		prevHash = entry.Hash
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	if a == nil && count > 0 {
+		return count, fmt.Errorf("%w: anchor is missing", ErrTampered)
+	}
+	l.mu.Lock()
+	lastSeq := l.lastSeq
+	l.mu.Unlock()
+	if a != nil && a.Seq > lastSeq {
+		lastSeq = a.Seq
+	}
+	if count < lastSeq {
+		return count, fmt.Errorf("%w: entries after %d are missing", ErrTampered, count)
+	}
+	return count, nil
+}
+
+// writeAnchor replaces the anchor with one naming entry. The file is written
+// beside it and renamed into place so a crash never leaves half an anchor.
+func (l *Log) writeAnchor(entry Entry) error {
+	a := anchor{Seq: entry.Seq, Hash: entry.Hash}
+	if len(l.key) > 0 {
+		a.MAC = l.anchorMAC(a)
+	}
+	dat, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(l.anchorPath), filepath.Base(l.anchorPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(dat)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Rename(f.Name(), l.anchorPath)
+}
+
+// readAnchor returns the anchor, or nil if there isn't one. With a key, an
+// anchor whose signature doesn't match is reported as tampering.
+func (l *Log) readAnchor() (*anchor, error) {
+	dat, err := os.ReadFile(l.anchorPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a := anchor{}
+	err = json.Unmarshal(dat, &a)
+	if err != nil {
+		return nil, fmt.Errorf("%w: anchor is malformed", ErrTampered)
+	}
+	if len(l.key) > 0 && !hmac.Equal([]byte(a.MAC), []byte(l.anchorMAC(a))) {
+		return nil, fmt.Errorf("%w: anchor doesn't match its signature", ErrTampered)
+	}
+	return &a, nil
+}
+
+func (l *Log) anchorMAC(a anchor) string {
+	h := hmac.New(sha256.New, l.key)
+	h.Write([]byte("audit-anchor\n" + strconv.Itoa(a.Seq) + "\n" + a.Hash))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sum hashes entry with its Hash field cleared.
+func (l *Log) sum(entry Entry) (string, error) {
+	entry.Hash = ""
+	dat, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+
+	var h hash.Hash
+	if len(l.key) > 0 {
+		h = hmac.New(sha256.New, l.key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(dat)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// each calls fn with every entry in order. A line that isn't valid JSON is
+// reported as tampering.
+func (l *Log) each(fn func(Entry) error) error {
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			entry := Entry{}
+			jsonErr := json.Unmarshal(line, &entry)
+			if jsonErr != nil {
+				return fmt.Errorf("%w: line %d is malformed", ErrTampered, lineNo)
+			}
+			fnErr := fn(entry)
+			if fnErr != nil {
+				return fnErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"strconv"
 	"time"
@@ -8,35 +12,147 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func MakeJWT(userID int, tokenSecret, issuedBy string, expiresIn time.Duration) (string, error) {
-	claims := &jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(time.Second * expiresIn)),
-		Issuer:    issuedBy,
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+// Claims are the claims carried by chirpy's tokens. Role is the user's role
+// when the token was issued; it is informational only, and authorization
+// always uses the role stored for the user. SessionID names the refresh family
+// the token was issued from, and Generation is the user's token generation
+// at the time. Tokens issued to OAuth clients name the client and carry the
+// space-separated scopes the user granted it.
+type Claims struct {
+	jwt.RegisteredClaims
+	Role       string `json:"role,omitempty"`
+	SessionID  string `json:"sid,omitempty"`
+	Generation int    `json:"gen,omitempty"`
+	ClientID   string `json:"client_id,omitempty"`
+	Scope      string `json:"scope,omitempty"`
+}
+
+// TokenOptions describe how tokens are issued and checked.
+type TokenOptions struct {
+	Issuer   string
+	Audience string
+	// Leeway is how far the verifier's clock may disagree with the issuer's
+	// when checking exp, nbf and iat.
+	Leeway time.Duration
+	// Now returns the current time, or time.Now if nil. Tests can set it to
+	// a fake clock.
+	Now func() time.Time
+}
+
+func (opts TokenOptions) now() time.Time {
+	if opts.Now != nil {
+		return opts.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// MakeJWT signs an access token for userID with keyring's active key,
+// carrying the private claims set in claims. Its registered claims are filled
+// in here, expiring expiresIn from now.
+func MakeJWT(userID int, claims Claims, keyring *Keyring, opts TokenOptions, expiresIn time.Duration) (string, error) {
+	tokenID, err := NewTokenID()
+	if err != nil {
+		return "", err
+	}
+	claims.RegisteredClaims = registeredClaims(userID, tokenID, opts, expiresIn)
+	return keyring.sign(&claims)
+}
+
+func registeredClaims(userID int, tokenID string, opts TokenOptions, expiresIn time.Duration) jwt.RegisteredClaims {
+	now := opts.now()
+	claims := jwt.RegisteredClaims{
+		ID:        tokenID,
+		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+		Issuer:    opts.Issuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
 		Subject:   strconv.Itoa(userID),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(tokenSecret))
+	if opts.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{opts.Audience}
+	}
+	return claims
 }
 
-func ValidateJWT(tokenString, tokenSecret, checkpram string) (string, error) {
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
-	)
+// NewTokenID returns a random identifier for tokens and refresh families.
+func NewTokenID() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// MakeRefreshToken returns a random opaque refresh token. Only its HashToken
+// should be stored.
+func MakeRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
 	if err != nil {
 		return "", err
 	}
-	if val, _ := token.Claims.GetIssuer(); val != checkpram {
-		return "", errors.New("Invalid JWT")
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// PersonalTokenPrefix starts every personal access token, so they are easy to
+// tell apart from JWTs and for secret scanners to spot.
+const PersonalTokenPrefix = "chirpy_pat_"
+
+// MakePersonalToken returns a random personal access token. Only its
+// HashToken should be stored.
+func MakePersonalToken() (string, error) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		return "", err
 	}
+	return PersonalTokenPrefix + token, nil
+}
 
-	userIDString, err := token.Claims.GetSubject()
+// HashToken returns the hex SHA-256 of token. Opaque tokens have enough
+// entropy that a fast unsalted hash is safe.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func ValidateJWT(tokenString string, keyring *Keyring, opts TokenOptions) (string, error) {
+	claims, err := ParseJWT(tokenString, keyring, opts)
 	if err != nil {
 		return "", err
 	}
+	return claims.Subject, nil
+}
+
+// ParseJWT validates tokenString like ValidateJWT but returns all of its
+// claims.
+func ParseJWT(tokenString string, keyring *Keyring, opts TokenOptions) (Claims, error) {
+	now := opts.now()
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{AlgHS256, AlgRS256, AlgEdDSA}),
+		jwt.WithIssuer(opts.Issuer),
+		jwt.WithLeeway(opts.Leeway),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(func() time.Time { return now }),
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+
+	claimsStruct := Claims{}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claimsStruct,
+		func(token *jwt.Token) (interface{}, error) { return keyring.key(token, now) },
+		parserOpts...,
+	)
+	if err != nil {
+		return Claims{}, err
+	}
+	if claimsStruct.Subject == "" {
+		return Claims{}, errors.New("Invalid JWT")
+	}
 
-	return userIDString, nil
+	return claimsStruct, nil
 }
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var issuedAt = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// clock returns a TokenOptions.Now that always reports t.
+func clock(t time.Time) func() time.Time {
+	return func() time.Time { return t }
+}
+
+func testOptions(now time.Time, leeway time.Duration) TokenOptions {
+	return TokenOptions{
+		Issuer:   "chirpy-access",
+		Audience: "chirpy",
+		Leeway:   leeway,
+		Now:      clock(now),
+	}
+}
+
+func TestMakeJWTExpiry(t *testing.T) {
+	keyring := NewHMACKeyring("test-secret")
+	token, err := MakeJWT(7, Claims{}, keyring, testOptions(issuedAt, 0), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseJWT(token, keyring, testOptions(issuedAt, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := claims.ExpiresAt.Time, issuedAt.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("exp = %v, want %v", got, want)
+	}
+	if !claims.IssuedAt.Time.Equal(issuedAt) || !claims.NotBefore.Time.Equal(issuedAt) {
+		t.Errorf("iat = %v, nbf = %v, want %v", claims.IssuedAt.Time, claims.NotBefore.Time, issuedAt)
+	}
+	if claims.Subject != "7" {
+		t.Errorf("sub = %q, want 7", claims.Subject)
+	}
+
+	tests := []struct {
+		name    string
+		at      time.Time
+		leeway  time.Duration
+		wantErr error
+	}{
+		{"just before expiry", issuedAt.Add(time.Hour - time.Second), 0, nil},
+		{"exactly at expiry", issuedAt.Add(time.Hour), 0, jwt.ErrTokenExpired},
+		{"after expiry", issuedAt.Add(time.Hour + time.Second), 0, jwt.ErrTokenExpired},
+		{"after expiry within leeway", issuedAt.Add(time.Hour + 29*time.Second), 30 * time.Second, nil},
+		{"after expiry beyond leeway", issuedAt.Add(time.Hour + 31*time.Second), 30 * time.Second, jwt.ErrTokenExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseJWT(token, keyring, testOptions(tt.at, tt.leeway))
+			checkErr(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParseJWTNotBefore(t *testing.T) {
+	keyring := NewHMACKeyring("test-secret")
+	token, err := MakeJWT(7, Claims{}, keyring, testOptions(issuedAt, 0), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		at      time.Time
+		leeway  time.Duration
+		wantErr error
+	}{
+		{"at issue", issuedAt, 0, nil},
+		{"verifier clock behind without leeway", issuedAt.Add(-10 * time.Second), 0, jwt.ErrTokenNotValidYet},
+		{"verifier clock behind within leeway", issuedAt.Add(-10 * time.Second), 30 * time.Second, nil},
+		{"verifier clock behind beyond leeway", issuedAt.Add(-31 * time.Second), 30 * time.Second, jwt.ErrTokenNotValidYet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseJWT(token, keyring, testOptions(tt.at, tt.leeway))
+			checkErr(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParseJWTIssuerAndAudience(t *testing.T) {
+	keyring := NewHMACKeyring("test-secret")
+	token, err := MakeJWT(7, Claims{}, keyring, testOptions(issuedAt, 0), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		issuer   string
+		audience string
+		wantErr  error
+	}{
+		{"matching", "chirpy-access", "chirpy", nil},
+		{"wrong audience", "chirpy-access", "other-service", jwt.ErrTokenInvalidAudience},
+		{"wrong issuer", "chirpy-refresh", "chirpy", jwt.ErrTokenInvalidIssuer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions(issuedAt, 0)
+			opts.Issuer = tt.issuer
+			opts.Audience = tt.audience
+			_, err := ParseJWT(token, keyring, opts)
+			checkErr(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestMakeJWTUniqueID(t *testing.T) {
+	keyring := NewHMACKeyring("test-secret")
+	opts := testOptions(issuedAt, 0)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		token, err := MakeJWT(7, Claims{}, keyring, opts, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := ParseJWT(token, keyring, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.ID == "" {
+			t.Fatal("token has no jti")
+		}
+		if seen[claims.ID] {
+			t.Fatalf("jti %q issued twice", claims.ID)
+		}
+		seen[claims.ID] = true
+	}
+}
+
+func checkErr(t *testing.T, err, want error) {
+	t.Helper()
+	if want == nil {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if !errors.Is(err, want) {
+		t.Fatalf("error = %v, want %v", err, want)
+	}
+}
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Key is one entry in a Keyring. HS256 keys hold a shared secret; EdDSA and
+// RS256 keys hold a private key if they can sign, or just a public key if
+// they are kept only to verify tokens they signed earlier.
+type Key struct {
+	ID        string
+	Algorithm string
+	// VerifyUntil ends the key's grace period. After it, tokens signed with
+	// the key are rejected. Zero means no limit.
+	VerifyUntil time.Time
+
+	secret  []byte
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+func (key *Key) method() jwt.SigningMethod {
+	switch key.Algorithm {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+func (key *Key) signingKey() interface{} {
+	if key.Algorithm == AlgHS256 {
+		return key.secret
+	}
+	return key.private
+}
+
+func (key *Key) verifyingKey() interface{} {
+	if key.Algorithm == AlgHS256 {
+		return key.secret
+	}
+	return key.public
+}
+
+// Keyring holds the key new tokens are signed with and every key tokens are
+// still accepted from. Tokens name their key with the kid header; a legacy
+// key verifies tokens issued before kid headers were added.
+type Keyring struct {
+	active *Key
+	legacy *Key
+	keys   map[string]*Key
+}
+
+// NewHMACKeyring returns a keyring holding just an HS256 key with secret,
+// which also accepts tokens without a kid header. This is what chirpy used
+// before keyrings.
+func NewHMACKeyring(secret string) *Keyring {
+	key := &Key{ID: "default", Algorithm: AlgHS256, secret: []byte(secret)}
+	return &Keyring{
+		active: key,
+		legacy: key,
+		keys:   map[string]*Key{key.ID: key},
+	}
+}
+
+// keyringFile is the JSON layout read by LoadKeyring. Key paths are relative
+// to the file.
+type keyringFile struct {
+	Active string `json:"active"`
+	Keys   []struct {
+		ID          string    `json:"kid"`
+		Algorithm   string    `json:"alg"`
+		SecretEnv   string    `json:"secret_env"`
+		PrivateKey  string    `json:"private_key"`
+		PublicKey   string    `json:"public_key"`
+		VerifyUntil time.Time `json:"verify_until"`
+		Legacy      bool      `json:"legacy"`
+	} `json:"keys"`
+}
+
+// LoadKeyring reads a keyring from the JSON file at path. HS256 secrets are
+// read from the environment variable each key names, and EdDSA and RS256
+// keys from PEM files.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := keyringFile{}
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	keyring := &Keyring{keys: map[string]*Key{}}
+	for _, entry := range file.Keys {
+		if entry.ID == "" {
+			return nil, errors.New("key without a kid")
+		}
+		if _, ok := keyring.keys[entry.ID]; ok {
+			return nil, fmt.Errorf("duplicate kid %q", entry.ID)
+		}
+		key := &Key{ID: entry.ID, Algorithm: entry.Algorithm, VerifyUntil: entry.VerifyUntil}
+
+		switch entry.Algorithm {
+		case AlgHS256:
+			secret := os.Getenv(entry.SecretEnv)
+			if entry.SecretEnv == "" || secret == "" {
+				return nil, fmt.Errorf("key %q: no secret in $%s", entry.ID, entry.SecretEnv)
+			}
+			key.secret = []byte(secret)
+		case AlgRS256, AlgEdDSA:
+			err = key.loadPEM(dir, entry.PrivateKey, entry.PublicKey)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", entry.ID, err)
+			}
+		default:
+			return nil, fmt.Errorf("key %q: unsupported alg %q", entry.ID, entry.Algorithm)
+		}
+
+		keyring.keys[key.ID] = key
+		if entry.Legacy {
+			if keyring.legacy != nil {
+				return nil, errors.New("more than one legacy key")
+			}
+			keyring.legacy = key
+		}
+	}
+
+	active, ok := keyring.keys[file.Active]
+	if !ok {
+		return nil, fmt.Errorf("active key %q not in keyring", file.Active)
+	}
+	if active.Algorithm != AlgHS256 && active.private == nil {
+		return nil, fmt.Errorf("active key %q has no private key", file.Active)
+	}
+	keyring.active = active
+
+	return keyring, nil
+}
+
+func (key *Key) loadPEM(dir, privatePath, publicPath string) error {
+	if privatePath != "" {
+		data, err := os.ReadFile(filepath.Join(dir, privatePath))
+		if err != nil {
+			return err
+		}
+		if key.Algorithm == AlgRS256 {
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return err
+			}
+			key.private = private
+		} else {
+			private, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return err
+			}
+			key.private = private.(crypto.Signer)
+		}
+		key.public = key.private.Public()
+		return nil
+	}
+
+	if publicPath == "" {
+		return errors.New("needs a private_key or public_key")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, publicPath))
+	if err != nil {
+		return err
+	}
+	if key.Algorithm == AlgRS256 {
+		key.public, err = jwt.ParseRSAPublicKeyFromPEM(data)
+	} else {
+		key.public, err = jwt.ParseEdPublicKeyFromPEM(data)
+	}
+	return err
+}
+
+// sign signs claims with the active key, naming it in the kid header.
+func (keyring *Keyring) sign(claims jwt.Claims) (string, error) {
+	key := keyring.active
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signingKey())
+}
+
+// key finds the key token claims to be signed with, refusing keys past their
+// grace period at now and tokens whose alg doesn't match their key.
+func (keyring *Keyring) key(token *jwt.Token, now time.Time) (interface{}, error) {
+	var key *Key
+	if kid, ok := token.Header["kid"].(string); ok {
+		key = keyring.keys[kid]
+	} else {
+		key = keyring.legacy
+	}
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+	if !key.VerifyUntil.IsZero() && now.After(key.VerifyUntil) {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.method().Alg() {
+		return nil, errors.New("signing method doesn't match key")
+	}
+	return key.verifyingKey(), nil
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKS returns the public halves of the keyring's asymmetric keys that are
+// still accepted, so other services can verify chirpy's tokens. HS256 keys
+// are never published.
+func (keyring *Keyring) JWKS() []JWK {
+	now := time.Now()
+	jwks := []JWK{}
+	for _, key := range keyring.keys {
+		if !key.VerifyUntil.IsZero() && now.After(key.VerifyUntil) {
+			continue
+		}
+		switch public := key.public.(type) {
+		case ed25519.PublicKey:
+			jwks = append(jwks, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Algorithm: AlgEdDSA,
+				Use:       "sig",
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		case *rsa.PublicKey:
+			jwks = append(jwks, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Algorithm: AlgRS256,
+				Use:       "sig",
+				N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		}
+	}
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].KeyID < jwks[j].KeyID })
+	return jwks
+}
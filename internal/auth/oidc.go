@@ -0,0 +1,390 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// maxOIDCResponseSize caps what is read from the provider, which is
+	// plenty for discovery documents, key sets and token responses.
+	maxOIDCResponseSize = 1 << 20
+	// minJWKSRefresh limits how often an unknown kid refetches the key set,
+	// so tokens with made-up kids can't turn chirpy into a request flood.
+	minJWKSRefresh = time.Minute
+)
+
+// oidcMethods are the algorithms accepted on ID tokens. HMAC is left out: it
+// would be keyed with the client secret, which chirpy doesn't treat as a
+// signing key.
+var oidcMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", AlgEdDSA}
+
+// IDClaims are the claims chirpy reads from an OpenID Connect ID token.
+type IDClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string `json:"nonce"`
+	AuthorizedParty string `json:"azp,omitempty"`
+	Email           string `json:"email"`
+	EmailVerified   bool   `json:"email_verified"`
+}
+
+// OIDCProvider signs users in with an external OpenID Connect provider using
+// the authorization code flow with PKCE. Its discovery document is fetched on
+// first use and its keys whenever an ID token names one not seen yet.
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// Leeway is how far the provider's clock may disagree with chirpy's.
+	Leeway time.Duration
+	Client *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewOIDCProvider configures a provider. The issuer must be https, or http on
+// the loopback interface for local development.
+func NewOIDCProvider(issuer, clientID, clientSecret, redirectURL string) (*OIDCProvider, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	if !secureURL(issuer) {
+		return nil, fmt.Errorf("OIDC issuer %q must be an https URL", issuer)
+	}
+	if clientID == "" {
+		return nil, errors.New("OIDC client ID is required")
+	}
+	if _, err := url.Parse(redirectURL); err != nil || redirectURL == "" {
+		return nil, fmt.Errorf("invalid OIDC redirect URL %q", redirectURL)
+	}
+	return &OIDCProvider{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email"},
+		Leeway:       30 * time.Second,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// secureURL reports whether raw is https, or http on the loopback interface.
+func secureURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Scheme == "https" {
+		return true
+	}
+	if u.Scheme != "http" {
+		return false
+	}
+	if u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+// AuthCodeURL returns the provider URL to send the user to. state and nonce
+// are bound to the login attempt and challenge is the S256 PKCE challenge.
+func (provider *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	discovery, err := provider.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.ClientID)
+	query.Set("redirect_uri", provider.RedirectURL)
+	query.Set("scope", strings.Join(provider.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", challenge)
+	query.Set("code_challenge_method", "S256")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems an authorization code at the token endpoint and returns
+// the claims of the verified ID token that comes back.
+func (provider *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (IDClaims, error) {
+	discovery, err := provider.discover(ctx)
+	if err != nil {
+		return IDClaims{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {provider.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if provider.ClientSecret == "" {
+		form.Set("client_id", provider.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return IDClaims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if provider.ClientSecret != "" {
+		// RFC 6749 section 2.3.1 form-encodes the credentials first.
+		req.SetBasicAuth(url.QueryEscape(provider.ClientID), url.QueryEscape(provider.ClientSecret))
+	}
+
+	var response struct {
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	err = provider.do(req, &response)
+	if err != nil && response.Error != "" {
+		return IDClaims{}, fmt.Errorf("token request failed: %s: %s", response.Error, response.Description)
+	}
+	if err != nil {
+		return IDClaims{}, fmt.Errorf("token request failed: %w", err)
+	}
+	if response.IDToken == "" {
+		return IDClaims{}, errors.New("token response has no ID token")
+	}
+
+	return provider.VerifyIDToken(ctx, response.IDToken, nonce)
+}
+
+// VerifyIDToken checks an ID token's signature against the provider's keys
+// and its issuer, audience, authorized party, lifetime and nonce.
+func (provider *OIDCProvider) VerifyIDToken(ctx context.Context, rawToken, nonce string) (IDClaims, error) {
+	claims := IDClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return provider.key(ctx, kid)
+	},
+		jwt.WithValidMethods(oidcMethods),
+		jwt.WithIssuer(provider.Issuer),
+		jwt.WithAudience(provider.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(provider.Leeway),
+	)
+	if err != nil {
+		return IDClaims{}, err
+	}
+	if claims.Subject == "" {
+		return IDClaims{}, errors.New("ID token has no subject")
+	}
+	// OpenID Connect Core section 3.1.3.7: a token issued to several
+	// audiences must name chirpy as the party it was issued to.
+	if (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != provider.ClientID {
+		return IDClaims{}, errors.New("ID token was issued to another client")
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return IDClaims{}, errors.New("ID token nonce doesn't match")
+	}
+	return claims, nil
+}
+
+// discover fetches and caches the provider's discovery document.
+func (provider *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if provider.discovery != nil {
+		return provider.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, provider.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	discovery := oidcDiscovery{}
+	err = provider.do(req, &discovery)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch OIDC discovery document: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != provider.Issuer {
+		return nil, fmt.Errorf("OIDC discovery document is for issuer %q", discovery.Issuer)
+	}
+	for _, endpoint := range []string{discovery.AuthorizationEndpoint, discovery.TokenEndpoint, discovery.JWKSURI} {
+		if !secureURL(endpoint) {
+			return nil, fmt.Errorf("OIDC discovery document has insecure endpoint %q", endpoint)
+		}
+	}
+	provider.discovery = &discovery
+	return provider.discovery, nil
+}
+
+// key returns the provider's public key with kid, refetching the key set if
+// it isn't known. An empty kid matches a key set holding a single key.
+func (provider *OIDCProvider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	discovery, err := provider.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if key, ok := provider.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(provider.keysFetchedAt) < minJWKSRefresh {
+		return nil, ErrUnknownKey
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discovery.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []JWK `json:"keys"`
+	}
+	err = provider.do(req, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch OIDC keys: %w", err)
+	}
+	provider.keysFetchedAt = time.Now()
+	provider.keys = map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// Keys of unsupported types are skipped rather than failing the
+		// whole set.
+		if key, err := jwk.PublicKey(); err == nil {
+			provider.keys[jwk.KeyID] = key
+		}
+	}
+
+	if key, ok := provider.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (provider *OIDCProvider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(provider.keys) == 1 {
+		for _, key := range provider.keys {
+			return key, true
+		}
+	}
+	key, ok := provider.keys[kid]
+	return key, ok
+}
+
+// do sends req and decodes its JSON response into v. Responses other than
+// 200 are still decoded, for their error fields, but reported as errors.
+func (provider *OIDCProvider) do(req *http.Request, v interface{}) error {
+	resp, err := provider.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponseSize)).Decode(v)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Redacted(), resp.Status)
+	}
+	return decodeErr
+}
+
+// PublicKey decodes the RSA, EC or Ed25519 public key jwk describes.
+func (jwk JWK) PublicKey() (crypto.PublicKey, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		var checked ecdh.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve, checked = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, checked = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, checked = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC point")
+		}
+		// Parsing the uncompressed point with crypto/ecdh checks it is on the
+		// curve.
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := checked.NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	case "OKP":
+		if jwk.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.KeyType)
+	}
+}
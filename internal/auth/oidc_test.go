@@ -0,0 +1,314 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID     = "chirpy"
+	testClientSecret = "client-secret"
+	testRedirectURL  = "http://localhost:8080/api/login/oidc/callback"
+	testCode         = "the-code"
+	testVerifier     = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testNonce        = "the-nonce"
+)
+
+// testIssuer is a minimal OpenID Connect provider serving discovery, a key
+// set and a token endpoint that answers testCode with an ID token.
+type testIssuer struct {
+	*httptest.Server
+	t    *testing.T
+	key  ed25519.PrivateKey
+	kid  string
+	hits map[string]int
+
+	// claims and header adjust the ID token before it is signed, and
+	// signWith replaces the key it is signed with.
+	claims   func(jwt.MapClaims)
+	header   func(map[string]interface{})
+	signWith ed25519.PrivateKey
+	// discoveryIssuer replaces the issuer in the discovery document.
+	discoveryIssuer string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{t: t, key: key, kid: "key-1", hits: map[string]int{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer.hits["discovery"]++
+		iss := issuer.URL
+		if issuer.discoveryIssuer != "" {
+			iss = issuer.discoveryIssuer
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 iss,
+			"authorization_endpoint": issuer.URL + "/authorize",
+			"token_endpoint":         issuer.URL + "/token",
+			"jwks_uri":               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.hits["jwks"]++
+		json.NewEncoder(w).Encode(map[string][]JWK{"keys": {{
+			KeyType: "OKP",
+			KeyID:   issuer.kid,
+			Use:     "sig",
+			Curve:   "Ed25519",
+			X:       base64.RawURLEncoding.EncodeToString(issuer.key.Public().(ed25519.PublicKey)),
+		}}})
+	})
+	mux.HandleFunc("/token", issuer.handleToken)
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func (issuer *testIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	issuer.hits["token"]++
+	r.ParseForm()
+	clientID, secret, _ := r.BasicAuth()
+	if clientID != testClientID || secret != testClientSecret ||
+		r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("code") != testCode ||
+		r.PostForm.Get("redirect_uri") != testRedirectURL ||
+		r.PostForm.Get("code_verifier") != testVerifier {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            issuer.URL,
+		"sub":            "subject-1",
+		"aud":            testClientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          testNonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+	if issuer.claims != nil {
+		issuer.claims(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = issuer.kid
+	if issuer.header != nil {
+		issuer.header(token.Header)
+	}
+	key := issuer.key
+	if issuer.signWith != nil {
+		key = issuer.signWith
+	}
+	idToken, err := token.SignedString(key)
+	if err != nil {
+		issuer.t.Error(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "opaque",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func (issuer *testIssuer) provider(t *testing.T) *OIDCProvider {
+	t.Helper()
+	provider, err := NewOIDCProvider(issuer.URL, testClientID, testClientSecret, testRedirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t)
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "the-state", testNonce, PKCEChallenge(testVerifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != issuer.URL+"/authorize" {
+		t.Errorf("authorization endpoint = %s", got)
+	}
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"scope":                 "openid email",
+		"state":                 "the-state",
+		"nonce":                 testNonce,
+		"code_challenge":        "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		"code_challenge_method": "S256",
+	}
+	for key, value := range want {
+		if got := u.Query().Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+
+	// Discovery is fetched once and cached.
+	_, err = provider.AuthCodeURL(context.Background(), "another-state", testNonce, PKCEChallenge(testVerifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issuer.hits["discovery"] != 1 {
+		t.Errorf("discovery fetched %d times, want 1", issuer.hits["discovery"])
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.discoveryIssuer = "https://evil.example.com"
+
+	_, err := issuer.provider(t).AuthCodeURL(context.Background(), "state", testNonce, PKCEChallenge(testVerifier))
+	if err == nil {
+		t.Fatal("discovery document for another issuer was accepted")
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t)
+
+	claims, err := provider.Exchange(context.Background(), testCode, testVerifier, testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != issuer.URL || claims.Subject != "subject-1" {
+		t.Errorf("iss = %q, sub = %q", claims.Issuer, claims.Subject)
+	}
+	if claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Errorf("email = %q, verified = %v", claims.Email, claims.EmailVerified)
+	}
+
+	// The key set is cached between logins.
+	_, err = provider.Exchange(context.Background(), testCode, testVerifier, testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issuer.hits["jwks"] != 1 {
+		t.Errorf("key set fetched %d times, want 1", issuer.hits["jwks"])
+	}
+}
+
+func TestOIDCExchangeRejects(t *testing.T) {
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		nonce    string
+		claims   func(jwt.MapClaims)
+		header   func(map[string]interface{})
+		signWith ed25519.PrivateKey
+		wantErr  error
+	}{
+		{name: "bad code", code: "wrong-code"},
+		{name: "bad nonce", claims: func(c jwt.MapClaims) { c["nonce"] = "replayed" }},
+		{name: "missing nonce", claims: func(c jwt.MapClaims) { delete(c, "nonce") }},
+		{name: "empty expected nonce", nonce: "-"},
+		{name: "wrong audience", claims: func(c jwt.MapClaims) { c["aud"] = "another-client" }, wantErr: jwt.ErrTokenInvalidAudience},
+		{name: "several audiences without azp", claims: func(c jwt.MapClaims) { c["aud"] = []string{testClientID, "another-client"} }},
+		{name: "azp names another client", claims: func(c jwt.MapClaims) {
+			c["aud"] = []string{testClientID, "another-client"}
+			c["azp"] = "another-client"
+		}},
+		{name: "wrong issuer", claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, wantErr: jwt.ErrTokenInvalidIssuer},
+		{name: "expired", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: jwt.ErrTokenExpired},
+		{name: "no expiry", claims: func(c jwt.MapClaims) { delete(c, "exp") }, wantErr: jwt.ErrTokenRequiredClaimMissing},
+		{name: "no subject", claims: func(c jwt.MapClaims) { delete(c, "sub") }},
+		{name: "unknown kid", header: func(h map[string]interface{}) { h["kid"] = "key-2" }, signWith: otherKey, wantErr: ErrUnknownKey},
+		{name: "wrong key for kid", signWith: otherKey, wantErr: jwt.ErrTokenSignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newTestIssuer(t)
+			issuer.claims = tt.claims
+			issuer.header = tt.header
+			issuer.signWith = tt.signWith
+			code := testCode
+			if tt.code != "" {
+				code = tt.code
+			}
+			nonce := testNonce
+			if tt.nonce == "-" {
+				nonce = ""
+			}
+
+			_, err := issuer.provider(t).Exchange(context.Background(), code, testVerifier, nonce)
+			if err == nil {
+				t.Fatal("Exchange succeeded")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDCRejectsHMACSignedWithClientSecret(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss":   issuer.URL,
+		"sub":   "subject-1",
+		"aud":   testClientID,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": testNonce,
+	})
+	idToken, err := token.SignedString([]byte(testClientSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.VerifyIDToken(context.Background(), idToken, testNonce)
+	if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+		t.Fatalf("error = %v, want %v", err, jwt.ErrTokenSignatureInvalid)
+	}
+}
+
+func TestNewOIDCProviderRequiresHTTPS(t *testing.T) {
+	tests := []struct {
+		issuer string
+		ok     bool
+	}{
+		{"https://idp.example.com", true},
+		{"http://localhost:9000", true},
+		{"http://127.0.0.1:9000", true},
+		{"http://idp.example.com", false},
+		{"idp.example.com", false},
+	}
+	for _, tt := range tests {
+		_, err := NewOIDCProvider(tt.issuer, testClientID, "", testRedirectURL)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewOIDCProvider(%q) error = %v, want ok = %v", tt.issuer, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound           = errors.New("blob not found")
+	ErrInvalidKey         = errors.New("invalid blob key")
+	ErrPresignUnsupported = errors.New("store does not support presigned URLs")
+)
+
+// Store is a flat namespace of blobs. Keys are slash-separated paths made of
+// letters, digits, '.', '-' and '_'.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns the blob's contents. Callers must close the reader. Stores
+	// backed by files return an io.ReadSeeker where they can.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	Delete(ctx context.Context, key string) error
+	// List calls fn with every key under prefix, stopping at the first error.
+	List(ctx context.Context, prefix string, fn func(key string) error) error
+	// PresignGet returns a URL that lets anyone holding it download the blob
+	// until expires has passed.
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// Copy copies every blob under prefix that is missing from dst over from
+// src. It returns how many of src's blobs are known to be in dst.
+func Copy(ctx context.Context, dst, src Store, prefix string) (int, error) {
+	return transfer(ctx, dst, src, prefix, false)
+}
+
+// Move is like Copy, but deletes each blob from src once it is in dst.
+func Move(ctx context.Context, dst, src Store, prefix string) (int, error) {
+	return transfer(ctx, dst, src, prefix, true)
+}
+
+func transfer(ctx context.Context, dst, src Store, prefix string, remove bool) (int, error) {
+	// Collect keys up front so deleting from src doesn't disturb listing.
+	keys := []string{}
+	err := src.List(ctx, prefix, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, key := range keys {
+		err = transferOne(ctx, dst, src, key)
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+		if remove {
+			err = src.Delete(ctx, key)
+			if err != nil {
+				return migrated, err
+			}
+		}
+	}
+	return migrated, nil
+}
+
+func transferOne(ctx context.Context, dst, src Store, key string) error {
+	ok, err := dst.Exists(ctx, key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+
+	rc, err := src.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+
+	// Local stores don't keep content types, so sniff it again rather than
+	// leave the copy without one.
+	return dst.Put(ctx, key, data, http.DetectContentType(data))
+}
+
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '/', r == '.', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,3 @@
+module github.com/JIsaacSamuel/chirpy/internal/blobstore
+
+go 1.21.5
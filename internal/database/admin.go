@@ -0,0 +1,231 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrEmailTaken = errors.New("email is already in use")
+
+// SearchUsers returns the users whose ID equals query or whose email contains
+// it, ignoring case, ordered by ID. An empty query matches everyone.
+func (db *DB) SearchUsers(query string) ([]User, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	queryID, _ := strconv.Atoi(query)
+
+	users := []User{}
+	for _, user := range dbStructure.Users {
+		if query == "" || user.ID == queryID || strings.Contains(strings.ToLower(user.EmailID), query) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	return users, nil
+}
+
+func (db *DB) GetChirpCount(userID int) (int, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.UserID == userID {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// SetUserEmail changes userID's email, which must not belong to anyone else.
+func (db *DB) SetUserEmail(userID int, email string) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		for _, other := range dbStructure.Users {
+			if other.ID != userID && strings.EqualFold(other.EmailID, email) {
+				return ErrEmailTaken
+			}
+		}
+		user.EmailID = email
+		dbStructure.Users[userID] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// SetSubscription changes whether userID has Chirpy Red, leaving the rest of
+// the user as it is.
+func (db *DB) SetSubscription(userID int, subscribed bool) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		user.Subscription = subscribed
+		dbStructure.Users[userID] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// ResetPassword replaces userID's password hash, marks the account as
+// needing a new password and logs it out everywhere.
+func (db *DB) ResetPassword(userID int, hashPass []byte) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		user.Password = hashPass
+		user.PasswordResetRequired = true
+		dbStructure.revokeAllSessions(&user, "password_reset", time.Now().UTC())
+		dbStructure.Users[userID] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// DeleteUser removes userID along with their chirps, reactions, media
+// records, follows, blocks, mutes, filters, refresh tokens, personal access
+// tokens, OAuth clients and linked identities. It returns the deleted chirps
+// and the IDs of users whose follow graph changed. Reports stay behind as a
+// record of moderation.
+func (db *DB) DeleteUser(userID int) ([]Chirp, []int, error) {
+	var chirps []Chirp
+	var affected []int
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[userID]; !ok {
+			return ErrNotExist
+		}
+
+		chirps = []Chirp{}
+		for id, chirp := range dbStructure.Chirps {
+			if chirp.UserID != userID {
+				continue
+			}
+			chirps = append(chirps, chirp)
+			dbStructure.unindexEntities(chirp)
+			delete(dbStructure.Chirps, id)
+			delete(dbStructure.Reactions, id)
+			delete(dbStructure.ReactionCounts, id)
+		}
+
+		for chirpID, byUser := range dbStructure.Reactions {
+			counts := dbStructure.ReactionCounts[chirpID]
+			for kind := range byUser[userID] {
+				counts[kind]--
+				if counts[kind] <= 0 {
+					delete(counts, kind)
+				}
+			}
+			delete(byUser, userID)
+			if len(byUser) == 0 {
+				delete(dbStructure.Reactions, chirpID)
+			}
+			if len(counts) == 0 {
+				delete(dbStructure.ReactionCounts, chirpID)
+			}
+		}
+
+		for id, media := range dbStructure.Media {
+			if media.OwnerID == userID {
+				delete(dbStructure.Media, id)
+			}
+		}
+
+		affected = []int{userID}
+		for followeeID := range dbStructure.Following[userID] {
+			dbStructure.removeFollow(userID, followeeID)
+			affected = append(affected, followeeID)
+		}
+		for followerID := range dbStructure.Followers[userID] {
+			dbStructure.removeFollow(followerID, userID)
+			affected = append(affected, followerID)
+		}
+
+		for blockedID := range dbStructure.Blocks[userID] {
+			delete(dbStructure.BlockedBy[blockedID], userID)
+		}
+		for blockerID := range dbStructure.BlockedBy[userID] {
+			delete(dbStructure.Blocks[blockerID], userID)
+		}
+		delete(dbStructure.Blocks, userID)
+		delete(dbStructure.BlockedBy, userID)
+		delete(dbStructure.Mutes, userID)
+		for _, mutes := range dbStructure.Mutes {
+			delete(mutes, userID)
+		}
+		delete(dbStructure.ContentFilters, userID)
+		delete(dbStructure.MentionIndex, userID)
+		for hash, token := range dbStructure.RefreshTokens {
+			if token.UserID == userID {
+				delete(dbStructure.RefreshTokens, hash)
+			}
+		}
+		for id, client := range dbStructure.OAuthClients {
+			if client.OwnerID == userID {
+				dbStructure.deleteOAuthClient(id, time.Now().UTC())
+			}
+		}
+		for hash, code := range dbStructure.OAuthCodes {
+			if code.UserID == userID {
+				delete(dbStructure.OAuthCodes, hash)
+			}
+		}
+		for key, identity := range dbStructure.Identities {
+			if identity.UserID == userID {
+				delete(dbStructure.Identities, key)
+			}
+		}
+		for id, token := range dbStructure.PersonalTokens {
+			if token.UserID == userID {
+				delete(dbStructure.PersonalTokens, id)
+			}
+		}
+		for id, family := range dbStructure.RefreshFamilies {
+			if family.UserID == userID {
+				delete(dbStructure.RefreshFamilies, id)
+			}
+		}
+
+		delete(dbStructure.Users, userID)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return chirps, affected, nil
+}
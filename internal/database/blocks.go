@@ -0,0 +1,224 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrBlocked      = errors.New("users have blocked each other")
+	ErrSelfRestrict = errors.New("users cannot block or mute themselves")
+)
+
+// Block stops BlockerID and BlockedID from seeing or interacting with each
+// other.
+type Block struct {
+	BlockerID int       `json:"blocker_id"`
+	BlockedID int       `json:"blocked_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Mute hides MutedID's chirps from MuterID only.
+type Mute struct {
+	MuterID   int       `json:"muter_id"`
+	MutedID   int       `json:"muted_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Block makes blockerID block blockedID and removes any follows between
+// them. Blocking someone twice is a no-op.
+func (db *DB) Block(blockerID, blockedID int) (Block, error) {
+	if blockerID == blockedID {
+		return Block{}, ErrSelfRestrict
+	}
+
+	var block Block
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[blockedID]; !ok {
+			return ErrNotExist
+		}
+		if existing, ok := dbStructure.Blocks[blockerID][blockedID]; ok {
+			block = existing
+			return nil
+		}
+
+		block = Block{
+			BlockerID: blockerID,
+			BlockedID: blockedID,
+			CreatedAt: time.Now().UTC(),
+		}
+		if dbStructure.Blocks[blockerID] == nil {
+			dbStructure.Blocks[blockerID] = map[int]Block{}
+		}
+		dbStructure.Blocks[blockerID][blockedID] = block
+		if dbStructure.BlockedBy[blockedID] == nil {
+			dbStructure.BlockedBy[blockedID] = map[int]Block{}
+		}
+		dbStructure.BlockedBy[blockedID][blockerID] = block
+
+		dbStructure.removeFollow(blockerID, blockedID)
+		dbStructure.removeFollow(blockedID, blockerID)
+		return nil
+	})
+	if err != nil {
+		return Block{}, err
+	}
+
+	return block, nil
+}
+
+func (db *DB) Unblock(blockerID, blockedID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Blocks[blockerID][blockedID]; !ok {
+			return nil
+		}
+		delete(dbStructure.Blocks[blockerID], blockedID)
+		if len(dbStructure.Blocks[blockerID]) == 0 {
+			delete(dbStructure.Blocks, blockerID)
+		}
+		delete(dbStructure.BlockedBy[blockedID], blockerID)
+		if len(dbStructure.BlockedBy[blockedID]) == 0 {
+			delete(dbStructure.BlockedBy, blockedID)
+		}
+		return nil
+	})
+}
+
+// Mute makes muterID mute mutedID. Muting someone twice is a no-op.
+func (db *DB) Mute(muterID, mutedID int) (Mute, error) {
+	if muterID == mutedID {
+		return Mute{}, ErrSelfRestrict
+	}
+
+	var mute Mute
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[mutedID]; !ok {
+			return ErrNotExist
+		}
+		if existing, ok := dbStructure.Mutes[muterID][mutedID]; ok {
+			mute = existing
+			return nil
+		}
+
+		mute = Mute{
+			MuterID:   muterID,
+			MutedID:   mutedID,
+			CreatedAt: time.Now().UTC(),
+		}
+		if dbStructure.Mutes[muterID] == nil {
+			dbStructure.Mutes[muterID] = map[int]Mute{}
+		}
+		dbStructure.Mutes[muterID][mutedID] = mute
+		return nil
+	})
+	if err != nil {
+		return Mute{}, err
+	}
+
+	return mute, nil
+}
+
+func (db *DB) Unmute(muterID, mutedID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Mutes[muterID][mutedID]; !ok {
+			return nil
+		}
+		delete(dbStructure.Mutes[muterID], mutedID)
+		if len(dbStructure.Mutes[muterID]) == 0 {
+			delete(dbStructure.Mutes, muterID)
+		}
+		return nil
+	})
+}
+
+// GetBlocks lists who userID has blocked, most recent first.
+func (db *DB) GetBlocks(userID int) ([]Block, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := []Block{}
+	for _, block := range dbStructure.Blocks[userID] {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].CreatedAt.After(blocks[j].CreatedAt)
+	})
+
+	return blocks, nil
+}
+
+// GetMutes lists who userID has muted, most recent first.
+func (db *DB) GetMutes(userID int) ([]Mute, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	mutes := []Mute{}
+	for _, mute := range dbStructure.Mutes[userID] {
+		mutes = append(mutes, mute)
+	}
+	sort.Slice(mutes, func(i, j int) bool {
+		return mutes[i].CreatedAt.After(mutes[j].CreatedAt)
+	})
+
+	return mutes, nil
+}
+
+// IsBlocked reports whether either user has blocked the other.
+func (db *DB) IsBlocked(userA, userB int) (bool, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+
+	return dbStructure.isBlocked(userA, userB), nil
+}
+
+// GetBlockedUsers returns everyone viewerID has blocked or been blocked by.
+func (db *DB) GetBlockedUsers(viewerID int) (map[int]struct{}, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	return dbStructure.blockedUsers(viewerID), nil
+}
+
+// GetHiddenUsers returns the users whose chirps viewerID shouldn't see:
+// anyone blocked by or blocking the viewer, and anyone the viewer muted.
+func (db *DB) GetHiddenUsers(viewerID int) (map[int]struct{}, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := dbStructure.blockedUsers(viewerID)
+	for id := range dbStructure.Mutes[viewerID] {
+		hidden[id] = struct{}{}
+	}
+
+	return hidden, nil
+}
+
+func (dbStructure *DBStructure) blockedUsers(userID int) map[int]struct{} {
+	blocked := map[int]struct{}{}
+	for id := range dbStructure.Blocks[userID] {
+		blocked[id] = struct{}{}
+	}
+	for id := range dbStructure.BlockedBy[userID] {
+		blocked[id] = struct{}{}
+	}
+	return blocked
+}
+
+func (dbStructure *DBStructure) isBlocked(userA, userB int) bool {
+	if _, ok := dbStructure.Blocks[userA][userB]; ok {
+		return true
+	}
+	_, ok := dbStructure.Blocks[userB][userA]
+	return ok
+}
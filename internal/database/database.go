@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -18,42 +19,103 @@ type User struct {
 	ID           int    `json:"id"`
 	Password     []byte `json:"password"`
 	Subscription bool   `json:"is_chirpy_red"`
+	Role         string `json:"role,omitempty"`
+
+	Suspension *Suspension `json:"suspension,omitempty"`
+	// PasswordResetRequired is set when an admin resets the password and
+	// cleared when the user picks a new one.
+	PasswordResetRequired bool `json:"password_reset_required,omitempty"`
+	// TokenGeneration is embedded in access tokens. Bumping it invalidates
+	// every access token issued before.
+	TokenGeneration int `json:"token_generation,omitempty"`
 }
 
 type DBStructure struct {
-	Chirps      map[int]Chirp         `json:"chirps"`
-	Users       map[int]User          `json:"users"`
-	Revocations map[string]Revocation `json:"revocations"`
+	Chirps map[int]Chirp `json:"chirps"`
+	Users  map[int]User  `json:"users"`
+
+	// RefreshTokens is keyed by token hash.
+	RefreshTokens   map[string]RefreshToken  `json:"refresh_tokens"`
+	RefreshFamilies map[string]RefreshFamily `json:"refresh_families"`
+
+	PersonalTokens map[int]PersonalToken `json:"personal_tokens"`
+
+	OAuthClients map[string]OAuthClient `json:"oauth_clients"`
+	// OAuthCodes is keyed by code hash.
+	OAuthCodes map[string]OAuthCode `json:"oauth_codes"`
+
+	// Identities is keyed by issuer and subject, and OIDCLogins by state
+	// hash.
+	Identities map[string]Identity  `json:"identities"`
+	OIDCLogins map[string]OIDCLogin `json:"oidc_logins"`
+
+	Reactions      map[int]map[int]map[string]Reaction `json:"reactions"`
+	ReactionCounts map[int]map[string]int              `json:"reaction_counts"`
+
+	TagIndex     map[string][]int `json:"tag_index"`
+	MentionIndex map[int][]int    `json:"mention_index"`
+
+	SuppressedTags map[string]TagSuppression `json:"suppressed_tags"`
+
+	Media map[int]Media `json:"media"`
+
+	// Following and Followers hold the same edges, keyed by follower and by
+	// followee respectively.
+	Following map[int]map[int]Follow `json:"following"`
+	Followers map[int]map[int]Follow `json:"followers"`
+
+	// Blocks and BlockedBy hold the same edges, keyed by blocker and by
+	// blocked user respectively.
+	Blocks    map[int]map[int]Block `json:"blocks"`
+	BlockedBy map[int]map[int]Block `json:"blocked_by"`
+	Mutes     map[int]map[int]Mute  `json:"mutes"`
+
+	ContentFilters map[int]map[int]ContentFilter `json:"content_filters"`
+
+	// ReportsByChirp maps chirp ID to reporter ID to report ID.
+	Reports        map[int]Report      `json:"reports"`
+	ReportsByChirp map[int]map[int]int `json:"reports_by_chirp"`
+
+	// LastIDs holds the last ID allocated in each collection with integer
+	// IDs.
+	LastIDs map[string]int `json:"last_ids"`
 }
 
 type Chirp struct {
 	UserID int    `json:"author_id"`
 	Body   string `json:"body"`
 	ID     int    `json:"id"`
-}
 
-type Revocation struct {
-	Token     string    `json:"token"`
-	RevokedAt time.Time `json:"revoked_at"`
-}
+	CreatedAt time.Time `json:"created_at"`
 
-var ErrNotExist = errors.New("resource does not exist")
+	Hashtags []Hashtag `json:"hashtags,omitempty"`
+	Mentions []Mention `json:"mentions,omitempty"`
+	MediaIDs []int     `json:"media_ids,omitempty"`
 
-func (db *DB) CreateChirp(body string, iD int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return Chirp{}, err
-	}
+	// Flagged names the moderation rules that flagged the chirp for review.
+	// CreateChirp files a report for such chirps so they reach moderators.
+	Flagged []string `json:"flagged,omitempty"`
+	// Hidden chirps were taken down by a moderator and are only shown to
+	// their author.
+	Hidden bool `json:"hidden,omitempty"`
+}
 
-	id := len(dbStructure.Chirps) + 1
-	chirp := Chirp{
-		ID:     id,
-		Body:   body,
-		UserID: iD,
-	}
-	dbStructure.Chirps[id] = chirp
+var ErrNotExist = errors.New("resource does not exist")
 
-	err = db.writeDB(dbStructure)
+// CreateChirp stores chirp, assigning its ID and creation time.
+func (db *DB) CreateChirp(chirp Chirp) (Chirp, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		id := nextID(dbStructure, "chirps", dbStructure.Chirps)
+		chirp.ID = id
+		chirp.CreatedAt = time.Now().UTC()
+		dbStructure.Chirps[id] = chirp
+		dbStructure.indexEntities(chirp)
+		if len(chirp.Flagged) > 0 {
+			note := "Flagged by " + strings.Join(chirp.Flagged, ", ")
+			dbStructure.fileReport(chirp, 0, ReasonFlagged, note)
+		}
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
@@ -91,6 +153,30 @@ func (db *DB) GetChirpsID(givenID int) ([]Chirp, error) {
 	return chirps, nil
 }
 
+func (db *DB) GetChirp(id int) (Chirp, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp, ok := dbStructure.Chirps[id]
+	if !ok {
+		return Chirp{}, ErrNotExist
+	}
+
+	return chirp, nil
+}
+
+// GetChirpsByIDs returns the chirps among ids that exist, in the order given.
+func (db *DB) GetChirpsByIDs(ids []int) ([]Chirp, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	return dbStructure.chirpsByID(ids), nil
+}
+
 func (db *DB) GetChirpByID(num int) (string, error) {
 	dbStructure, err := db.loadDB()
 	if err != nil {
@@ -107,21 +193,33 @@ func (db *DB) GetChirpByID(num int) (string, error) {
 }
 
 func (db *DB) DeleteChirpByID(num int) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-	if _, ok := dbStructure.Chirps[num]; ok {
-		delete(dbStructure.Chirps, num)
-	}
+	return db.update(func(dbStructure *DBStructure) error {
+		if chirp, ok := dbStructure.Chirps[num]; ok {
+			dbStructure.unindexEntities(chirp)
+			delete(dbStructure.Chirps, num)
+		}
+		delete(dbStructure.Reactions, num)
+		delete(dbStructure.ReactionCounts, num)
+		dbStructure.removeChirpReports(num)
+		return nil
+	})
+}
 
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
+// nextID allocates the next ID in collection, whose records are in m. The
+// last ID handed out is persisted, so IDs are never reused after records are
+// deleted; a reused user ID would let a deleted user's tokens act as the new
+// user. The counter starts above the largest key in m for databases written
+// before counters existed.
+func nextID[T any](dbStructure *DBStructure, collection string, m map[int]T) int {
+	last := dbStructure.LastIDs[collection]
+	for id := range m {
+		if id > last {
+			last = id
+		}
 	}
-
-	return nil
+	last++
+	dbStructure.LastIDs[collection] = last
+	return last
 }
 
 func NewDB(path string) (*DB, error) {
@@ -134,27 +232,23 @@ func NewDB(path string) (*DB, error) {
 }
 
 func (db *DB) CreateUser(emailAdd string, hashPass []byte) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-	id := len(dbStructure.Users) + 1
-	user := User{
-		ID:       id,
-		Password: hashPass,
-		EmailID:  emailAdd,
-	}
-
-	for _, value := range dbStructure.Users {
-		if value.EmailID == emailAdd {
-			return User{}, errors.New("User already exists")
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		for _, value := range dbStructure.Users {
+			if value.EmailID == emailAdd {
+				return errors.New("User already exists")
+			}
 		}
-	}
-
-	dbStructure.Users[id] = user
 
-	err = db.writeDB(dbStructure)
+		id := nextID(dbStructure, "users", dbStructure.Users)
+		user = User{
+			ID:       id,
+			Password: hashPass,
+			EmailID:  emailAdd,
+		}
+		dbStructure.Users[id] = user
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -163,20 +257,19 @@ func (db *DB) CreateUser(emailAdd string, hashPass []byte) (User, error) {
 }
 
 func (db *DB) UpdateUser(userID int, newEmail string, newHashPass []byte) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-	tempUser, ok := dbStructure.Users[userID]
-	if !ok {
-		return User{}, ErrNotExist
-	}
-	tempUser.EmailID = newEmail
-	tempUser.Password = newHashPass
-	dbStructure.Users[userID] = tempUser
-
-	err = db.writeDB(dbStructure)
+	var tempUser User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		tempUser, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		tempUser.EmailID = newEmail
+		tempUser.Password = newHashPass
+		tempUser.PasswordResetRequired = false
+		dbStructure.Users[userID] = tempUser
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -185,23 +278,14 @@ func (db *DB) UpdateUser(userID int, newEmail string, newHashPass []byte) (User,
 }
 
 func (db *DB) GenUpdateUser(updatedUser User, userID int) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-	_, ok := dbStructure.Users[userID]
-	if !ok {
-		return ErrNotExist
-	}
-	dbStructure.Users[userID] = updatedUser
-
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return db.update(func(dbStructure *DBStructure) error {
+		_, ok := dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		dbStructure.Users[userID] = updatedUser
+		return nil
+	})
 }
 
 func (db *DB) GetUser(emailAdd string) (User, error) {
@@ -234,13 +318,30 @@ func (db *DB) GetUserID(IDNum int) (User, error) {
 	return User{}, errors.New("User not found")
 }
 
-func (db *DB) createDB() error {
-	dbStructure := DBStructure{
-		Chirps:      map[int]Chirp{},
-		Users:       map[int]User{},
-		Revocations: map[string]Revocation{},
+// GetUsersByIDs returns the users among ids that exist, keyed by ID.
+func (db *DB) GetUsersByIDs(ids []int) (map[int]User, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
 	}
-	return db.writeDB(dbStructure)
+
+	users := map[int]User{}
+	for _, id := range ids {
+		if user, ok := dbStructure.Users[id]; ok {
+			users[id] = user
+		}
+	}
+
+	return users, nil
+}
+
+func (db *DB) createDB() error {
+	dbStructure := DBStructure{}
+	dbStructure.initMaps()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.writeFile(dbStructure)
 }
 
 func (db *DB) ensureDB() error {
@@ -255,6 +356,29 @@ func (db *DB) loadDB() (DBStructure, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
+	return db.readFile()
+}
+
+// update loads the database, applies fn and writes the result back, holding
+// the write lock throughout so concurrent changes can't interleave and undo
+// each other. Nothing is written if fn returns an error. Every change to the
+// database goes through update; a snapshot from loadDB is only for reading.
+func (db *DB) update(fn func(*DBStructure) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStructure, err := db.readFile()
+	if err != nil {
+		return err
+	}
+	err = fn(&dbStructure)
+	if err != nil {
+		return err
+	}
+	return db.writeFile(dbStructure)
+}
+
+func (db *DB) readFile() (DBStructure, error) {
 	dbStructure := DBStructure{}
 	dat, err := os.ReadFile(db.path)
 	if errors.Is(err, os.ErrNotExist) {
@@ -264,60 +388,97 @@ func (db *DB) loadDB() (DBStructure, error) {
 	if err != nil {
 		return dbStructure, err
 	}
+	dbStructure.initMaps()
 
 	return dbStructure, nil
 }
 
-func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	dat, err := json.Marshal(dbStructure)
-	if err != nil {
-		return err
+// initMaps fills in any collections missing from the file on disk, so that
+// databases written by older versions keep working as new ones are added.
+func (dbStructure *DBStructure) initMaps() {
+	if dbStructure.Chirps == nil {
+		dbStructure.Chirps = map[int]Chirp{}
 	}
-
-	err = os.WriteFile(db.path, dat, 0600)
-	if err != nil {
-		return err
+	if dbStructure.Users == nil {
+		dbStructure.Users = map[int]User{}
+	}
+	if dbStructure.RefreshTokens == nil {
+		dbStructure.RefreshTokens = map[string]RefreshToken{}
+	}
+	if dbStructure.RefreshFamilies == nil {
+		dbStructure.RefreshFamilies = map[string]RefreshFamily{}
+	}
+	if dbStructure.PersonalTokens == nil {
+		dbStructure.PersonalTokens = map[int]PersonalToken{}
+	}
+	if dbStructure.OAuthClients == nil {
+		dbStructure.OAuthClients = map[string]OAuthClient{}
+	}
+	if dbStructure.OAuthCodes == nil {
+		dbStructure.OAuthCodes = map[string]OAuthCode{}
+	}
+	if dbStructure.Identities == nil {
+		dbStructure.Identities = map[string]Identity{}
+	}
+	if dbStructure.OIDCLogins == nil {
+		dbStructure.OIDCLogins = map[string]OIDCLogin{}
+	}
+	if dbStructure.Reactions == nil {
+		dbStructure.Reactions = map[int]map[int]map[string]Reaction{}
+	}
+	if dbStructure.ReactionCounts == nil {
+		dbStructure.ReactionCounts = map[int]map[string]int{}
+	}
+	if dbStructure.TagIndex == nil {
+		dbStructure.TagIndex = map[string][]int{}
+	}
+	if dbStructure.MentionIndex == nil {
+		dbStructure.MentionIndex = map[int][]int{}
+	}
+	if dbStructure.SuppressedTags == nil {
+		dbStructure.SuppressedTags = map[string]TagSuppression{}
+	}
+	if dbStructure.Media == nil {
+		dbStructure.Media = map[int]Media{}
+	}
+	if dbStructure.Following == nil {
+		dbStructure.Following = map[int]map[int]Follow{}
+	}
+	if dbStructure.Followers == nil {
+		dbStructure.Followers = map[int]map[int]Follow{}
+	}
+	if dbStructure.Blocks == nil {
+		dbStructure.Blocks = map[int]map[int]Block{}
+	}
+	if dbStructure.BlockedBy == nil {
+		dbStructure.BlockedBy = map[int]map[int]Block{}
+	}
+	if dbStructure.Mutes == nil {
+		dbStructure.Mutes = map[int]map[int]Mute{}
+	}
+	if dbStructure.ContentFilters == nil {
+		dbStructure.ContentFilters = map[int]map[int]ContentFilter{}
+	}
+	if dbStructure.Reports == nil {
+		dbStructure.Reports = map[int]Report{}
+	}
+	if dbStructure.ReportsByChirp == nil {
+		dbStructure.ReportsByChirp = map[int]map[int]int{}
+	}
+	if dbStructure.LastIDs == nil {
+		dbStructure.LastIDs = map[string]int{}
 	}
-	return nil
 }
 
-func (db *DB) RevokeToken(token string) error {
-	dbStructure, err := db.loadDB()
+func (db *DB) writeFile(dbStructure DBStructure) error {
+	dat, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
 	}
 
-	revocation := Revocation{
-		Token:     token,
-		RevokedAt: time.Now().UTC(),
-	}
-	dbStructure.Revocations[token] = revocation
-
-	err = db.writeDB(dbStructure)
+	err = os.WriteFile(db.path, dat, 0600)
 	if err != nil {
 		return err
 	}
-
 	return nil
 }
-
-func (db *DB) IsTokenRevoked(token string) (bool, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return false, err
-	}
-
-	revocation, ok := dbStructure.Revocations[token]
-	if !ok {
-		return false, nil
-	}
-
-	if revocation.RevokedAt.IsZero() {
-		return false, nil
-	}
-
-	return true, nil
-}
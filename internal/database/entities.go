@@ -0,0 +1,142 @@
+package database
+
+import (
+	"errors"
+	"strings"
+)
+
+// Hashtag is a #tag found in a chirp body. Start and End are rune offsets
+// into the body, End exclusive, and include the leading '#'.
+type Hashtag struct {
+	Tag   string `json:"tag"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// Mention is an @handle in a chirp body that resolved to a user. Start and
+// End are rune offsets into the body, End exclusive, and include the '@'.
+type Mention struct {
+	UserID int    `json:"user_id"`
+	Handle string `json:"handle"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+}
+
+var ErrAmbiguousHandle = errors.New("handle matches more than one user")
+
+// GetUserByHandle finds the user whose email local part matches handle,
+// ignoring case.
+func (db *DB) GetUserByHandle(handle string) (User, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	found := []User{}
+	for _, user := range dbStructure.Users {
+		localPart, _, ok := strings.Cut(user.EmailID, "@")
+		if ok && strings.EqualFold(localPart, handle) {
+			found = append(found, user)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return User{}, ErrNotExist
+	case 1:
+		return found[0], nil
+	default:
+		return User{}, ErrAmbiguousHandle
+	}
+}
+
+// GetChirpsByTag returns every chirp tagged with tag, which must already be
+// lower-cased.
+func (db *DB) GetChirpsByTag(tag string) ([]Chirp, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	return dbStructure.chirpsByID(dbStructure.TagIndex[tag]), nil
+}
+
+// GetChirpsMentioning returns every chirp that mentions userID.
+func (db *DB) GetChirpsMentioning(userID int) ([]Chirp, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	return dbStructure.chirpsByID(dbStructure.MentionIndex[userID]), nil
+}
+
+func (dbStructure *DBStructure) chirpsByID(ids []int) []Chirp {
+	chirps := make([]Chirp, 0, len(ids))
+	for _, id := range ids {
+		if chirp, ok := dbStructure.Chirps[id]; ok {
+			chirps = append(chirps, chirp)
+		}
+	}
+	return chirps
+}
+
+func (dbStructure *DBStructure) indexEntities(chirp Chirp) {
+	for _, tag := range uniqueTags(chirp.Hashtags) {
+		dbStructure.TagIndex[tag] = append(dbStructure.TagIndex[tag], chirp.ID)
+	}
+	for _, userID := range uniqueMentions(chirp.Mentions) {
+		dbStructure.MentionIndex[userID] = append(dbStructure.MentionIndex[userID], chirp.ID)
+	}
+}
+
+func (dbStructure *DBStructure) unindexEntities(chirp Chirp) {
+	for _, tag := range uniqueTags(chirp.Hashtags) {
+		dbStructure.TagIndex[tag] = removeID(dbStructure.TagIndex[tag], chirp.ID)
+		if len(dbStructure.TagIndex[tag]) == 0 {
+			delete(dbStructure.TagIndex, tag)
+		}
+	}
+	for _, userID := range uniqueMentions(chirp.Mentions) {
+		dbStructure.MentionIndex[userID] = removeID(dbStructure.MentionIndex[userID], chirp.ID)
+		if len(dbStructure.MentionIndex[userID]) == 0 {
+			delete(dbStructure.MentionIndex, userID)
+		}
+	}
+}
+
+func uniqueTags(hashtags []Hashtag) []string {
+	seen := map[string]struct{}{}
+	tags := []string{}
+	for _, hashtag := range hashtags {
+		if _, ok := seen[hashtag.Tag]; ok {
+			continue
+		}
+		seen[hashtag.Tag] = struct{}{}
+		tags = append(tags, hashtag.Tag)
+	}
+	return tags
+}
+
+func uniqueMentions(mentions []Mention) []int {
+	seen := map[int]struct{}{}
+	userIDs := []int{}
+	for _, mention := range mentions {
+		if _, ok := seen[mention.UserID]; ok {
+			continue
+		}
+		seen[mention.UserID] = struct{}{}
+		userIDs = append(userIDs, mention.UserID)
+	}
+	return userIDs
+}
+
+func removeID(ids []int, id int) []int {
+	kept := ids[:0]
+	for _, v := range ids {
+		if v != id {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
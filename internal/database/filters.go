@@ -0,0 +1,78 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// ContentFilter is a muted keyword, phrase or hashtag registered by a user.
+// Chirps matching it are hidden or blurred when that user reads them.
+type ContentFilter struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	Kind      string     `json:"kind"`
+	Value     string     `json:"value"`
+	Action    string     `json:"action"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Active reports whether filter still applies at now.
+func (filter ContentFilter) Active(now time.Time) bool {
+	return filter.ExpiresAt == nil || now.Before(*filter.ExpiresAt)
+}
+
+// CreateContentFilter stores filter, assigning its ID and creation time.
+func (db *DB) CreateContentFilter(filter ContentFilter) (ContentFilter, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		filters, ok := dbStructure.ContentFilters[filter.UserID]
+		if !ok {
+			filters = map[int]ContentFilter{}
+			dbStructure.ContentFilters[filter.UserID] = filters
+		}
+		filter.ID = nextID(dbStructure, "content_filters", filters)
+		filter.CreatedAt = time.Now().UTC()
+		filters[filter.ID] = filter
+		return nil
+	})
+	if err != nil {
+		return ContentFilter{}, err
+	}
+
+	return filter, nil
+}
+
+func (db *DB) DeleteContentFilter(userID, filterID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.ContentFilters[userID][filterID]; !ok {
+			return ErrNotExist
+		}
+		delete(dbStructure.ContentFilters[userID], filterID)
+		if len(dbStructure.ContentFilters[userID]) == 0 {
+			delete(dbStructure.ContentFilters, userID)
+		}
+		return nil
+	})
+}
+
+// GetContentFilters lists userID's filters that haven't expired, oldest
+// first.
+func (db *DB) GetContentFilters(userID int) ([]ContentFilter, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	filters := []ContentFilter{}
+	for _, filter := range dbStructure.ContentFilters[userID] {
+		if filter.Active(now) {
+			filters = append(filters, filter)
+		}
+	}
+	sort.Slice(filters, func(i, j int) bool {
+		return filters[i].ID < filters[j].ID
+	})
+
+	return filters, nil
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrSelfFollow = errors.New("users cannot follow themselves")
+
+// Follow is one edge of the follow graph: FollowerID follows FolloweeID.
+type Follow struct {
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Follow makes followerID follow followeeID. Following someone twice is a
+// no-op.
+func (db *DB) Follow(followerID, followeeID int) (Follow, error) {
+	if followerID == followeeID {
+		return Follow{}, ErrSelfFollow
+	}
+
+	var follow Follow
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[followeeID]; !ok {
+			return ErrNotExist
+		}
+		if dbStructure.isBlocked(followerID, followeeID) {
+			return ErrBlocked
+		}
+		if existing, ok := dbStructure.Following[followerID][followeeID]; ok {
+			follow = existing
+			return nil
+		}
+
+		follow = Follow{
+			FollowerID: followerID,
+			FolloweeID: followeeID,
+			CreatedAt:  time.Now().UTC(),
+		}
+		dbStructure.addFollow(follow)
+		return nil
+	})
+	if err != nil {
+		return Follow{}, err
+	}
+
+	return follow, nil
+}
+
+// Unfollow removes the edge from followerID to followeeID, if there is one.
+func (db *DB) Unfollow(followerID, followeeID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Following[followerID][followeeID]; !ok {
+			return nil
+		}
+		dbStructure.removeFollow(followerID, followeeID)
+		return nil
+	})
+}
+
+// GetFollowers lists who follows userID, most recent first.
+func (db *DB) GetFollowers(userID int) ([]Follow, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	return sortFollows(dbStructure.Followers[userID]), nil
+}
+
+// GetFollowing lists who userID follows, most recent first.
+func (db *DB) GetFollowing(userID int) ([]Follow, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	return sortFollows(dbStructure.Following[userID]), nil
+}
+
+// GetFollowCounts returns how many users follow userID and how many userID
+// follows.
+func (db *DB) GetFollowCounts(userID int) (followers int, following int, err error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return len(dbStructure.Followers[userID]), len(dbStructure.Following[userID]), nil
+}
+
+// GetFollowerCounts returns how many followers each of userIDs has.
+func (db *DB) GetFollowerCounts(userIDs []int) (map[int]int, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[int]int{}
+	for _, id := range userIDs {
+		counts[id] = len(dbStructure.Followers[id])
+	}
+
+	return counts, nil
+}
+
+// GetChirpsByAuthors returns up to limit chirps written by any of authorIDs,
+// newest first, starting below beforeID. A beforeID of 0 starts from the
+// newest chirp.
+func (db *DB) GetChirpsByAuthors(authorIDs []int, beforeID, limit int) ([]Chirp, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	authors := map[int]struct{}{}
+	for _, id := range authorIDs {
+		authors[id] = struct{}{}
+	}
+
+	chirps := []Chirp{}
+	for id, chirp := range dbStructure.Chirps {
+		if beforeID > 0 && id >= beforeID {
+			continue
+		}
+		if _, ok := authors[chirp.UserID]; ok {
+			chirps = append(chirps, chirp)
+		}
+	}
+	sort.Slice(chirps, func(i, j int) bool {
+		return chirps[i].ID > chirps[j].ID
+	})
+	if len(chirps) > limit {
+		chirps = chirps[:limit]
+	}
+
+	return chirps, nil
+}
+
+// addFollow records follow in both directions so either side of the graph
+// can be read without a scan.
+func (dbStructure *DBStructure) addFollow(follow Follow) {
+	if dbStructure.Following[follow.FollowerID] == nil {
+		dbStructure.Following[follow.FollowerID] = map[int]Follow{}
+	}
+	dbStructure.Following[follow.FollowerID][follow.FolloweeID] = follow
+
+	if dbStructure.Followers[follow.FolloweeID] == nil {
+		dbStructure.Followers[follow.FolloweeID] = map[int]Follow{}
+	}
+	dbStructure.Followers[follow.FolloweeID][follow.FollowerID] = follow
+}
+
+func (dbStructure *DBStructure) removeFollow(followerID, followeeID int) {
+	delete(dbStructure.Following[followerID], followeeID)
+	if len(dbStructure.Following[followerID]) == 0 {
+		delete(dbStructure.Following, followerID)
+	}
+	delete(dbStructure.Followers[followeeID], followerID)
+	if len(dbStructure.Followers[followeeID]) == 0 {
+		delete(dbStructure.Followers, followeeID)
+	}
+}
+
+func sortFollows(edges map[int]Follow) []Follow {
+	follows := make([]Follow, 0, len(edges))
+	for _, follow := range edges {
+		follows = append(follows, follow)
+	}
+	sort.Slice(follows, func(i, j int) bool {
+		return follows[i].CreatedAt.After(follows[j].CreatedAt)
+	})
+	return follows
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrIdentityConflict = errors.New("user is linked to another identity at this issuer")
+	ErrAmbiguousEmail   = errors.New("email matches more than one user")
+)
+
+// Identity links an account at an external OpenID Connect provider, named by
+// issuer and subject, to a user.
+type Identity struct {
+	Issuer      string    `json:"issuer"`
+	Subject     string    `json:"subject"`
+	UserID      int       `json:"user_id"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// OIDCLogin is a sign-in with an external provider in progress, stored by
+// state hash until the provider redirects back.
+type OIDCLogin struct {
+	StateHash    string    `json:"state_hash"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func identityKey(issuer, subject string) string {
+	return issuer + " " + subject
+}
+
+// GetIdentity returns the identity for subject at issuer, recording that it
+// was used to log in.
+func (db *DB) GetIdentity(issuer, subject string) (Identity, error) {
+	var identity Identity
+	err := db.update(func(dbStructure *DBStructure) error {
+		key := identityKey(issuer, subject)
+		var ok bool
+		identity, ok = dbStructure.Identities[key]
+		if !ok {
+			return ErrNotExist
+		}
+		identity.LastLoginAt = time.Now().UTC()
+		dbStructure.Identities[key] = identity
+		return nil
+	})
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return identity, nil
+}
+
+// LinkIdentity links identity to its user. A user can only have one identity
+// per issuer, so ErrIdentityConflict is returned if they already have another.
+func (db *DB) LinkIdentity(identity Identity) (Identity, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[identity.UserID]; !ok {
+			return ErrNotExist
+		}
+		for _, existing := range dbStructure.Identities {
+			if existing.UserID == identity.UserID && existing.Issuer == identity.Issuer {
+				return ErrIdentityConflict
+			}
+		}
+
+		now := time.Now().UTC()
+		identity.CreatedAt = now
+		identity.LastLoginAt = now
+		dbStructure.Identities[identityKey(identity.Issuer, identity.Subject)] = identity
+		return nil
+	})
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return identity, nil
+}
+
+// GetUserByEmailFold finds the user with email, ignoring case. An exact match
+// wins; otherwise the email must match exactly one user.
+func (db *DB) GetUserByEmailFold(email string) (User, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	found := []User{}
+	for _, user := range dbStructure.Users {
+		if user.EmailID == email {
+			return user, nil
+		}
+		if strings.EqualFold(user.EmailID, email) {
+			found = append(found, user)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return User{}, ErrNotExist
+	case 1:
+		return found[0], nil
+	default:
+		return User{}, ErrAmbiguousEmail
+	}
+}
+
+// GetUserIdentities lists the identities linked to userID, oldest first.
+func (db *DB) GetUserIdentities(userID int) ([]Identity, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	identities := []Identity{}
+	for _, identity := range dbStructure.Identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		return identities[i].CreatedAt.Before(identities[j].CreatedAt)
+	})
+
+	return identities, nil
+}
+
+// CreateOIDCLogin stores login, forgetting logins that have expired.
+func (db *DB) CreateOIDCLogin(login OIDCLogin) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for hash, existing := range dbStructure.OIDCLogins {
+			if !now.Before(existing.ExpiresAt) {
+				delete(dbStructure.OIDCLogins, hash)
+			}
+		}
+		dbStructure.OIDCLogins[login.StateHash] = login
+		return nil
+	})
+}
+
+// TakeOIDCLogin removes and returns the login with stateHash, so each state
+// can only complete one sign-in. The lookup and removal happen under one
+// write lock, so concurrent callbacks with the same state can't both take it.
+func (db *DB) TakeOIDCLogin(stateHash string) (OIDCLogin, error) {
+	var login OIDCLogin
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		login, ok = dbStructure.OIDCLogins[stateHash]
+		if !ok {
+			return ErrNotExist
+		}
+		delete(dbStructure.OIDCLogins, stateHash)
+		return nil
+	})
+	if err != nil {
+		return OIDCLogin{}, err
+	}
+
+	if !time.Now().UTC().Before(login.ExpiresAt) {
+		return OIDCLogin{}, ErrTokenExpired
+	}
+	return login, nil
+}
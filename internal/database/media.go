@@ -0,0 +1,64 @@
+package database
+
+import "time"
+
+// Media is an uploaded image. The blob keys point into the media store.
+type Media struct {
+	ID           int       `json:"id"`
+	OwnerID      int       `json:"owner_id"`
+	ContentType  string    `json:"content_type"`
+	Size         int       `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	BlobKey      string    `json:"blob_key"`
+	ThumbnailKey string    `json:"thumbnail_key"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreateMedia stores media, assigning its ID and creation time.
+func (db *DB) CreateMedia(media Media) (Media, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		media.ID = nextID(dbStructure, "media", dbStructure.Media)
+		media.CreatedAt = time.Now().UTC()
+		dbStructure.Media[media.ID] = media
+		return nil
+	})
+	if err != nil {
+		return Media{}, err
+	}
+
+	return media, nil
+}
+
+func (db *DB) GetMedia(id int) (Media, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Media{}, err
+	}
+
+	media, ok := dbStructure.Media[id]
+	if !ok {
+		return Media{}, ErrNotExist
+	}
+
+	return media, nil
+}
+
+// GetMediaForChirps returns the media attached to any of chirps, keyed by ID.
+func (db *DB) GetMediaForChirps(chirps []Chirp) (map[int]Media, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	media := map[int]Media{}
+	for _, chirp := range chirps {
+		for _, id := range chirp.MediaIDs {
+			if m, ok := dbStructure.Media[id]; ok {
+				media[id] = m
+			}
+		}
+	}
+
+	return media, nil
+}
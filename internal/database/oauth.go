@@ -0,0 +1,176 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// OAuthClient is a third-party app registered by a user. Public clients,
+// such as mobile and single-page apps, have no secret and rely on PKCE alone.
+type OAuthClient struct {
+	ID           string    `json:"id"`
+	SecretHash   string    `json:"secret_hash,omitempty"`
+	Name         string    `json:"name"`
+	OwnerID      int       `json:"owner_id"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Confidential reports whether the client must authenticate with a secret.
+func (client OAuthClient) Confidential() bool {
+	return client.SecretHash != ""
+}
+
+// OAuthCode is an authorization code, stored by hash, that the client
+// exchanges for tokens once.
+type OAuthCode struct {
+	Hash          string     `json:"hash"`
+	ClientID      string     `json:"client_id"`
+	UserID        int        `json:"user_id"`
+	RedirectURI   string     `json:"redirect_uri"`
+	Scopes        []string   `json:"scopes"`
+	CodeChallenge string     `json:"code_challenge"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	RedeemedAt    *time.Time `json:"redeemed_at,omitempty"`
+}
+
+func (db *DB) CreateOAuthClient(client OAuthClient) (OAuthClient, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		client.CreatedAt = time.Now().UTC()
+		dbStructure.OAuthClients[client.ID] = client
+		return nil
+	})
+	if err != nil {
+		return OAuthClient{}, err
+	}
+
+	return client, nil
+}
+
+func (db *DB) GetOAuthClient(id string) (OAuthClient, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return OAuthClient{}, err
+	}
+
+	client, ok := dbStructure.OAuthClients[id]
+	if !ok {
+		return OAuthClient{}, ErrNotExist
+	}
+
+	return client, nil
+}
+
+// GetOAuthClients lists the clients ownerID registered, oldest first.
+func (db *DB) GetOAuthClients(ownerID int) ([]OAuthClient, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	clients := []OAuthClient{}
+	for _, client := range dbStructure.OAuthClients {
+		if client.OwnerID == ownerID {
+			clients = append(clients, client)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].CreatedAt.Before(clients[j].CreatedAt)
+	})
+
+	return clients, nil
+}
+
+// DeleteOAuthClient removes ownerID's client with id, its unused codes and
+// every refresh token family issued to it.
+func (db *DB) DeleteOAuthClient(ownerID int, id string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		client, ok := dbStructure.OAuthClients[id]
+		if !ok || client.OwnerID != ownerID {
+			return ErrNotExist
+		}
+		dbStructure.deleteOAuthClient(id, time.Now().UTC())
+		return nil
+	})
+}
+
+func (dbStructure *DBStructure) deleteOAuthClient(id string, now time.Time) {
+	delete(dbStructure.OAuthClients, id)
+	for hash, code := range dbStructure.OAuthCodes {
+		if code.ClientID == id {
+			delete(dbStructure.OAuthCodes, hash)
+		}
+	}
+	dbStructure.revokeClientFamilies(0, id, "client_deleted", now)
+}
+
+// revokeClientFamilies revokes the refresh token families issued to
+// clientID, only those for userID unless it is zero.
+func (dbStructure *DBStructure) revokeClientFamilies(userID int, clientID, reason string, now time.Time) {
+	for familyID, family := range dbStructure.RefreshFamilies {
+		if family.ClientID != clientID || family.RevokedAt != nil {
+			continue
+		}
+		if userID != 0 && family.UserID != userID {
+			continue
+		}
+		family.RevokedAt = &now
+		family.RevokedReason = reason
+		dbStructure.RefreshFamilies[familyID] = family
+	}
+}
+
+// CreateOAuthCode stores code, forgetting codes that have expired.
+func (db *DB) CreateOAuthCode(code OAuthCode) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for hash, existing := range dbStructure.OAuthCodes {
+			if !now.Before(existing.ExpiresAt) {
+				delete(dbStructure.OAuthCodes, hash)
+			}
+		}
+		dbStructure.OAuthCodes[code.Hash] = code
+		return nil
+	})
+}
+
+// RedeemOAuthCode marks the code with hash as used and returns it. A code
+// presented a second time was intercepted or replayed, so every token the
+// client holds for that user is revoked and ErrTokenReused returned. The
+// user's token generation is bumped too, since the access token issued from
+// the first redemption would otherwise stay valid until it expires.
+func (db *DB) RedeemOAuthCode(hash string) (OAuthCode, error) {
+	var code OAuthCode
+	reused := false
+	err := db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		var ok bool
+		code, ok = dbStructure.OAuthCodes[hash]
+		if !ok {
+			return ErrNotExist
+		}
+		if code.RedeemedAt != nil {
+			dbStructure.revokeClientFamilies(code.UserID, code.ClientID, "code_reuse", now)
+			if user, ok := dbStructure.Users[code.UserID]; ok {
+				user.TokenGeneration++
+				dbStructure.Users[code.UserID] = user
+			}
+			reused = true
+			return nil
+		}
+		if !now.Before(code.ExpiresAt) {
+			return ErrTokenExpired
+		}
+		code.RedeemedAt = &now
+		dbStructure.OAuthCodes[hash] = code
+		return nil
+	})
+	if err != nil {
+		return OAuthCode{}, err
+	}
+	if reused {
+		return code, ErrTokenReused
+	}
+
+	return code, nil
+}
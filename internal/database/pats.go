@@ -0,0 +1,125 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// PersonalToken is a long-lived, named token a user creates for scripts and
+// bots. Like refresh tokens, only its hash is stored.
+type PersonalToken struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+	Hash   string `json:"hash"`
+	// Hint is the start of the token, shown so users can tell tokens apart.
+	Hint       string     `json:"hint"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Active reports whether token can still be used at now.
+func (token PersonalToken) Active(now time.Time) bool {
+	return token.ExpiresAt == nil || now.Before(*token.ExpiresAt)
+}
+
+// CreatePersonalToken stores token, assigning its ID and creation time.
+func (db *DB) CreatePersonalToken(token PersonalToken) (PersonalToken, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		token.ID = nextID(dbStructure, "personal_tokens", dbStructure.PersonalTokens)
+		token.CreatedAt = time.Now().UTC()
+		dbStructure.PersonalTokens[token.ID] = token
+		return nil
+	})
+	if err != nil {
+		return PersonalToken{}, err
+	}
+
+	return token, nil
+}
+
+// GetPersonalTokens lists userID's tokens, oldest first, including expired
+// ones so users can see why a script stopped working.
+func (db *DB) GetPersonalTokens(userID int) ([]PersonalToken, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []PersonalToken{}
+	for _, token := range dbStructure.PersonalTokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+
+	return tokens, nil
+}
+
+// personalTokenTouchInterval limits how often a token's LastUsedAt is
+// written, so busy scripts don't rewrite the database on every request.
+const personalTokenTouchInterval = time.Minute
+
+// UsePersonalToken returns the active token with hash, recording that it was
+// used. The token is looked up again under the write lock before it is
+// touched, so a token deleted in the meantime stays deleted.
+func (db *DB) UsePersonalToken(hash string) (PersonalToken, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return PersonalToken{}, err
+	}
+	now := time.Now().UTC()
+	_, token, err := dbStructure.activePersonalToken(hash, now)
+	if err != nil {
+		return PersonalToken{}, err
+	}
+	if token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < personalTokenTouchInterval {
+		return token, nil
+	}
+
+	err = db.update(func(dbStructure *DBStructure) error {
+		id, current, err := dbStructure.activePersonalToken(hash, now)
+		if err != nil {
+			return err
+		}
+		current.LastUsedAt = &now
+		dbStructure.PersonalTokens[id] = current
+		token = current
+		return nil
+	})
+	if err != nil {
+		return PersonalToken{}, err
+	}
+
+	return token, nil
+}
+
+// activePersonalToken finds the token with hash, returning ErrTokenExpired
+// if it can't be used at now.
+func (dbStructure *DBStructure) activePersonalToken(hash string, now time.Time) (int, PersonalToken, error) {
+	for id, token := range dbStructure.PersonalTokens {
+		if token.Hash != hash {
+			continue
+		}
+		if !token.Active(now) {
+			return 0, PersonalToken{}, ErrTokenExpired
+		}
+		return id, token, nil
+	}
+	return 0, PersonalToken{}, ErrNotExist
+}
+
+// DeletePersonalToken revokes userID's token with tokenID.
+func (db *DB) DeletePersonalToken(userID, tokenID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		token, ok := dbStructure.PersonalTokens[tokenID]
+		if !ok || token.UserID != userID {
+			return ErrNotExist
+		}
+		delete(dbStructure.PersonalTokens, tokenID)
+		return nil
+	})
+}
@@ -0,0 +1,128 @@
+package database
+
+import "time"
+
+type Reaction struct {
+	ChirpID   int       `json:"chirp_id"`
+	UserID    int       `json:"user_id"`
+	Kind      string    `json:"reaction"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AddReaction records a reaction by userID on chirpID. Reacting twice with the
+// same kind is a no-op, so clients can safely retry.
+func (db *DB) AddReaction(chirpID, userID int, kind string) (Reaction, error) {
+	var reaction Reaction
+	err := db.update(func(dbStructure *DBStructure) error {
+		chirp, ok := dbStructure.Chirps[chirpID]
+		if !ok {
+			return ErrNotExist
+		}
+		if dbStructure.isBlocked(userID, chirp.UserID) {
+			return ErrBlocked
+		}
+
+		byUser, ok := dbStructure.Reactions[chirpID]
+		if !ok {
+			byUser = map[int]map[string]Reaction{}
+			dbStructure.Reactions[chirpID] = byUser
+		}
+		kinds, ok := byUser[userID]
+		if !ok {
+			kinds = map[string]Reaction{}
+			byUser[userID] = kinds
+		}
+		if existing, ok := kinds[kind]; ok {
+			reaction = existing
+			return nil
+		}
+
+		reaction = Reaction{
+			ChirpID:   chirpID,
+			UserID:    userID,
+			Kind:      kind,
+			CreatedAt: time.Now().UTC(),
+		}
+		kinds[kind] = reaction
+
+		counts, ok := dbStructure.ReactionCounts[chirpID]
+		if !ok {
+			counts = map[string]int{}
+			dbStructure.ReactionCounts[chirpID] = counts
+		}
+		counts[kind]++
+		return nil
+	})
+	if err != nil {
+		return Reaction{}, err
+	}
+
+	return reaction, nil
+}
+
+// RemoveReaction deletes a reaction by userID on chirpID. Removing a reaction
+// that does not exist is a no-op.
+func (db *DB) RemoveReaction(chirpID, userID int, kind string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Chirps[chirpID]; !ok {
+			return ErrNotExist
+		}
+
+		kinds := dbStructure.Reactions[chirpID][userID]
+		if _, ok := kinds[kind]; !ok {
+			return nil
+		}
+		delete(kinds, kind)
+		if len(kinds) == 0 {
+			delete(dbStructure.Reactions[chirpID], userID)
+		}
+		if len(dbStructure.Reactions[chirpID]) == 0 {
+			delete(dbStructure.Reactions, chirpID)
+		}
+
+		counts := dbStructure.ReactionCounts[chirpID]
+		counts[kind]--
+		if counts[kind] <= 0 {
+			delete(counts, kind)
+		}
+		if len(counts) == 0 {
+			delete(dbStructure.ReactionCounts, chirpID)
+		}
+		return nil
+	})
+}
+
+// GetReactions lists every reaction on chirpID, optionally restricted to one
+// kind when kind is non-empty.
+func (db *DB) GetReactions(chirpID int, kind string) ([]Reaction, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := dbStructure.Chirps[chirpID]; !ok {
+		return nil, ErrNotExist
+	}
+
+	reactions := []Reaction{}
+	for _, kinds := range dbStructure.Reactions[chirpID] {
+		for k, reaction := range kinds {
+			if kind != "" && k != kind {
+				continue
+			}
+			reactions = append(reactions, reaction)
+		}
+	}
+
+	return reactions, nil
+}
+
+// GetReactionCounts returns the aggregated reaction counts keyed by chirp ID.
+func (db *DB) GetReactionCounts() (map[int]map[string]int, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	return dbStructure.ReactionCounts, nil
+}
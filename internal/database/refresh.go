@@ -0,0 +1,306 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrTokenRevoked = errors.New("refresh token has been revoked")
+	ErrTokenExpired = errors.New("refresh token has expired")
+	ErrTokenReused  = errors.New("refresh token was already used")
+)
+
+// RefreshToken is a refresh token as stored server-side. Only the SHA-256
+// hash of the token is kept, so a copy of the database can't be used to
+// refresh. Tokens issued to OAuth clients carry the client's ID and the
+// scopes the user granted it.
+type RefreshToken struct {
+	Hash      string    `json:"hash"`
+	UserID    int       `json:"user_id"`
+	FamilyID  string    `json:"family_id"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+}
+
+// RefreshFamily is the chain of refresh tokens descended from one login,
+// and is what users see as a session. Only the token with CurrentHash may be
+// exchanged; presenting any earlier token from the family means it was
+// stolen or replayed, and the whole family is revoked.
+type RefreshFamily struct {
+	ID            string     `json:"id"`
+	UserID        int        `json:"user_id"`
+	ClientID      string     `json:"client_id,omitempty"`
+	CurrentHash   string     `json:"current_hash"`
+	CreatedAt     time.Time  `json:"created_at"`
+	RotatedAt     time.Time  `json:"rotated_at"`
+	LastUsedAt    time.Time  `json:"last_used_at,omitempty"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	UserAgent     string     `json:"user_agent,omitempty"`
+	IP            string     `json:"ip,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason string     `json:"revoked_reason,omitempty"`
+}
+
+// LastUsed returns when the family was last refreshed or one of its access
+// tokens was used. Families stored before LastUsedAt existed only have
+// RotatedAt.
+func (family RefreshFamily) LastUsed() time.Time {
+	if family.LastUsedAt.After(family.RotatedAt) {
+		return family.LastUsedAt
+	}
+	return family.RotatedAt
+}
+
+// Active reports whether the family can still be refreshed at now.
+func (family RefreshFamily) Active(now time.Time) bool {
+	return family.RevokedAt == nil && now.Before(family.ExpiresAt)
+}
+
+// CreateRefreshFamily stores token as the first of a new family with ID
+// familyID.
+func (db *DB) CreateRefreshFamily(familyID string, token RefreshToken) (RefreshToken, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		dbStructure.pruneRefreshTokens(now)
+		token.FamilyID = familyID
+		token.CreatedAt = now
+		dbStructure.RefreshFamilies[familyID] = RefreshFamily{
+			ID:          familyID,
+			UserID:      token.UserID,
+			ClientID:    token.ClientID,
+			CurrentHash: token.Hash,
+			CreatedAt:   now,
+			RotatedAt:   now,
+			LastUsedAt:  now,
+			ExpiresAt:   token.ExpiresAt,
+			UserAgent:   token.UserAgent,
+			IP:          token.IP,
+		}
+		dbStructure.RefreshTokens[token.Hash] = token
+		return nil
+	})
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	return token, nil
+}
+
+func (db *DB) GetRefreshToken(hash string) (RefreshToken, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	token, ok := dbStructure.RefreshTokens[hash]
+	if !ok {
+		return RefreshToken{}, ErrNotExist
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken exchanges the token with hash for next, which joins the
+// same family. Presenting a token that has already been rotated revokes the
+// family and returns ErrTokenReused.
+func (db *DB) RotateRefreshToken(hash string, next RefreshToken) (RefreshToken, error) {
+	var token RefreshToken
+	reused := false
+	err := db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		var ok bool
+		token, ok = dbStructure.RefreshTokens[hash]
+		if !ok {
+			return ErrNotExist
+		}
+		family, ok := dbStructure.RefreshFamilies[token.FamilyID]
+		if !ok {
+			return ErrNotExist
+		}
+		if family.RevokedAt != nil {
+			return ErrTokenRevoked
+		}
+		if family.CurrentHash != hash {
+			family.RevokedAt = &now
+			family.RevokedReason = "reuse"
+			dbStructure.RefreshFamilies[family.ID] = family
+			reused = true
+			return nil
+		}
+		if !now.Before(token.ExpiresAt) {
+			return ErrTokenExpired
+		}
+
+		next.UserID = token.UserID
+		next.FamilyID = family.ID
+		next.ClientID = token.ClientID
+		next.Scopes = token.Scopes
+		next.CreatedAt = now
+		dbStructure.RefreshTokens[next.Hash] = next
+		family.CurrentHash = next.Hash
+		family.RotatedAt = now
+		family.LastUsedAt = now
+		family.ExpiresAt = next.ExpiresAt
+		family.UserAgent = next.UserAgent
+		family.IP = next.IP
+		dbStructure.RefreshFamilies[family.ID] = family
+		return nil
+	})
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if reused {
+		return token, ErrTokenReused
+	}
+
+	return next, nil
+}
+
+// RevokeRefreshToken revokes the family of the token with hash and returns
+// the token. Revoking a revoked family is a no-op.
+func (db *DB) RevokeRefreshToken(hash, reason string) (RefreshToken, error) {
+	var token RefreshToken
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		token, ok = dbStructure.RefreshTokens[hash]
+		if !ok {
+			return ErrNotExist
+		}
+		family, ok := dbStructure.RefreshFamilies[token.FamilyID]
+		if !ok || family.RevokedAt != nil {
+			return nil
+		}
+		now := time.Now().UTC()
+		family.RevokedAt = &now
+		family.RevokedReason = reason
+		dbStructure.RefreshFamilies[family.ID] = family
+		return nil
+	})
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	return token, nil
+}
+
+// sessionTouchInterval limits how often a session's LastUsedAt is written as
+// its access tokens are used, like personalTokenTouchInterval.
+const sessionTouchInterval = time.Minute
+
+// TouchSession records that an access token issued from familyID was used.
+// Unknown and revoked families are ignored.
+func (db *DB) TouchSession(familyID string) error {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	family, ok := dbStructure.RefreshFamilies[familyID]
+	if !ok || family.RevokedAt != nil || now.Sub(family.LastUsed()) < sessionTouchInterval {
+		return nil
+	}
+
+	return db.update(func(dbStructure *DBStructure) error {
+		family, ok := dbStructure.RefreshFamilies[familyID]
+		if !ok || family.RevokedAt != nil {
+			return nil
+		}
+		family.LastUsedAt = now
+		dbStructure.RefreshFamilies[familyID] = family
+		return nil
+	})
+}
+
+// GetSessions returns userID's active refresh families, most recently used
+// first.
+func (db *DB) GetSessions(userID int) ([]RefreshFamily, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	sessions := []RefreshFamily{}
+	for _, family := range dbStructure.RefreshFamilies {
+		if family.UserID == userID && family.Active(now) {
+			sessions = append(sessions, family)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsed().After(sessions[j].LastUsed())
+	})
+
+	return sessions, nil
+}
+
+// RevokeSession revokes userID's refresh family with ID familyID.
+func (db *DB) RevokeSession(userID int, familyID string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		family, ok := dbStructure.RefreshFamilies[familyID]
+		if !ok || family.UserID != userID || !family.Active(now) {
+			return ErrNotExist
+		}
+		family.RevokedAt = &now
+		family.RevokedReason = "logout"
+		dbStructure.RefreshFamilies[familyID] = family
+		return nil
+	})
+}
+
+// RevokeAllSessions revokes every refresh family belonging to userID and
+// bumps their token generation, invalidating every access token issued so
+// far.
+func (db *DB) RevokeAllSessions(userID int, reason string) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		dbStructure.revokeAllSessions(&user, reason, time.Now().UTC())
+		dbStructure.Users[userID] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (dbStructure *DBStructure) revokeAllSessions(user *User, reason string, now time.Time) {
+	for id, family := range dbStructure.RefreshFamilies {
+		if family.UserID == user.ID && family.RevokedAt == nil {
+			family.RevokedAt = &now
+			family.RevokedReason = reason
+			dbStructure.RefreshFamilies[id] = family
+		}
+	}
+	user.TokenGeneration++
+}
+
+// pruneRefreshTokens forgets tokens past their expiry, and families with no
+// tokens left. Rotated tokens are kept until then so reuse can be detected.
+func (dbStructure *DBStructure) pruneRefreshTokens(now time.Time) {
+	live := map[string]struct{}{}
+	for hash, token := range dbStructure.RefreshTokens {
+		if now.After(token.ExpiresAt) {
+			delete(dbStructure.RefreshTokens, hash)
+			continue
+		}
+		live[token.FamilyID] = struct{}{}
+	}
+	for id := range dbStructure.RefreshFamilies {
+		if _, ok := live[id]; !ok {
+			delete(dbStructure.RefreshFamilies, id)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	ReportStatusOpen     = "open"
+	ReportStatusClaimed  = "claimed"
+	ReportStatusResolved = "resolved"
+
+	ResolutionDismiss       = "dismiss"
+	ResolutionHideChirp     = "hide_chirp"
+	ResolutionSuspendAuthor = "suspend_author"
+
+	// ReasonFlagged is the reason on reports filed by the system for chirps
+	// that moderation rules flagged for review. Those reports have no
+	// reporter.
+	ReasonFlagged = "flagged"
+)
+
+var (
+	ErrDuplicateReport = errors.New("chirp already reported by this user")
+	ErrReportClaimed   = errors.New("report is claimed by another moderator")
+	ErrReportResolved  = errors.New("report is already resolved")
+)
+
+// Report is one user's complaint about a chirp. Reports move from open to
+// claimed by a moderator to resolved.
+type Report struct {
+	ID         int       `json:"id"`
+	ChirpID    int       `json:"chirp_id"`
+	AuthorID   int       `json:"author_id"`
+	ReporterID int       `json:"reporter_id,omitempty"`
+	Reason     string    `json:"reason"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Status     string    `json:"status"`
+
+	ClaimedBy int        `json:"claimed_by,omitempty"`
+	ClaimedAt *time.Time `json:"claimed_at,omitempty"`
+
+	Resolution     string     `json:"resolution,omitempty"`
+	ResolutionNote string     `json:"resolution_note,omitempty"`
+	ResolvedBy     int        `json:"resolved_by,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+}
+
+// CreateReport files a report against chirpID by reporterID. Each user can
+// report a given chirp only once.
+func (db *DB) CreateReport(chirpID, reporterID int, reason, note string) (Report, error) {
+	var report Report
+	err := db.update(func(dbStructure *DBStructure) error {
+		chirp, ok := dbStructure.Chirps[chirpID]
+		if !ok {
+			return ErrNotExist
+		}
+		if _, ok := dbStructure.ReportsByChirp[chirpID][reporterID]; ok {
+			return ErrDuplicateReport
+		}
+
+		report = dbStructure.fileReport(chirp, reporterID, reason, note)
+		return nil
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	return report, nil
+}
+
+// fileReport adds an open report against chirp to the queue.
+func (dbStructure *DBStructure) fileReport(chirp Chirp, reporterID int, reason, note string) Report {
+	id := nextID(dbStructure, "reports", dbStructure.Reports)
+	report := Report{
+		ID:         id,
+		ChirpID:    chirp.ID,
+		AuthorID:   chirp.UserID,
+		ReporterID: reporterID,
+		Reason:     reason,
+		Note:       note,
+		CreatedAt:  time.Now().UTC(),
+		Status:     ReportStatusOpen,
+	}
+	dbStructure.Reports[id] = report
+	if dbStructure.ReportsByChirp[chirp.ID] == nil {
+		dbStructure.ReportsByChirp[chirp.ID] = map[int]int{}
+	}
+	dbStructure.ReportsByChirp[chirp.ID][reporterID] = id
+	return report
+}
+
+// removeChirpReports drops every report against chirpID.
+func (dbStructure *DBStructure) removeChirpReports(chirpID int) {
+	for _, reportID := range dbStructure.ReportsByChirp[chirpID] {
+		delete(dbStructure.Reports, reportID)
+	}
+	delete(dbStructure.ReportsByChirp, chirpID)
+}
+
+// GetReports returns the reports with the given status, or all reports if
+// status is empty, oldest first.
+func (db *DB) GetReports(status string) ([]Report, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []Report{}
+	for _, report := range dbStructure.Reports {
+		if status == "" || report.Status == status {
+			reports = append(reports, report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID < reports[j].ID })
+
+	return reports, nil
+}
+
+// ClaimReport assigns an open report to moderatorID. Claiming a report the
+// moderator already holds is a no-op.
+func (db *DB) ClaimReport(id, moderatorID int) (Report, error) {
+	var report Report
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		report, ok = dbStructure.Reports[id]
+		if !ok {
+			return ErrNotExist
+		}
+		switch report.Status {
+		case ReportStatusResolved:
+			return ErrReportResolved
+		case ReportStatusClaimed:
+			if report.ClaimedBy != moderatorID {
+				return ErrReportClaimed
+			}
+			return nil
+		}
+
+		report.Status = ReportStatusClaimed
+		report.ClaimedBy = moderatorID
+		now := time.Now().UTC()
+		report.ClaimedAt = &now
+		dbStructure.Reports[id] = report
+		return nil
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	return report, nil
+}
+
+// ResolveReport applies resolution to the reported chirp or its author and
+// closes every unresolved report on the same chirp with it. A report claimed
+// by another moderator can't be resolved.
+func (db *DB) ResolveReport(id, moderatorID int, resolution, note string) (Report, error) {
+	var resolved Report
+	err := db.update(func(dbStructure *DBStructure) error {
+		report, ok := dbStructure.Reports[id]
+		if !ok {
+			return ErrNotExist
+		}
+		if report.Status == ReportStatusResolved {
+			return ErrReportResolved
+		}
+		if report.Status == ReportStatusClaimed && report.ClaimedBy != moderatorID {
+			return ErrReportClaimed
+		}
+
+		now := time.Now().UTC()
+		switch resolution {
+		case ResolutionDismiss:
+		case ResolutionHideChirp:
+			if chirp, ok := dbStructure.Chirps[report.ChirpID]; ok {
+				chirp.Hidden = true
+				dbStructure.Chirps[report.ChirpID] = chirp
+			}
+		case ResolutionSuspendAuthor:
+			user, ok := dbStructure.Users[report.AuthorID]
+			if ok && !dbStructure.Users[moderatorID].Outranks(user) {
+				return ErrOutranked
+			}
+			if ok && user.Suspension == nil {
+				user.Suspension = &Suspension{
+					Reason:      report.Reason,
+					SuspendedBy: moderatorID,
+					SuspendedAt: now,
+				}
+				dbStructure.Users[report.AuthorID] = user
+			}
+		default:
+			return errors.New("unknown resolution")
+		}
+
+		for _, reportID := range dbStructure.ReportsByChirp[report.ChirpID] {
+			related := dbStructure.Reports[reportID]
+			if related.Status == ReportStatusResolved {
+				continue
+			}
+			related.Status = ReportStatusResolved
+			related.Resolution = resolution
+			related.ResolutionNote = note
+			related.ResolvedBy = moderatorID
+			related.ResolvedAt = &now
+			dbStructure.Reports[reportID] = related
+		}
+		resolved = dbStructure.Reports[id]
+		return nil
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	return resolved, nil
+}
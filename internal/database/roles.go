@@ -0,0 +1,63 @@
+package database
+
+import "errors"
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var (
+	ErrInvalidRole = errors.New("invalid role")
+	ErrOutranked   = errors.New("user's role doesn't rank below the moderator's")
+)
+
+// roleRanks orders roles by privilege.
+var roleRanks = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
+}
+
+// EffectiveRole returns user's role. Users stored before roles existed are plain
+// users.
+func (user User) EffectiveRole() string {
+	if user.Role == "" {
+		return RoleUser
+	}
+	return user.Role
+}
+
+// Outranks reports whether user's role ranks above other's. Moderators may
+// only act against users they outrank.
+func (user User) Outranks(other User) bool {
+	return roleRanks[user.EffectiveRole()] > roleRanks[other.EffectiveRole()]
+}
+
+func (db *DB) SetUserRole(userID int, role string) (User, error) {
+	if !ValidRole(role) {
+		return User{}, ErrInvalidRole
+	}
+
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		user.Role = role
+		dbStructure.Users[userID] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
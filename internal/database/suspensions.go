@@ -0,0 +1,92 @@
+package database
+
+import "time"
+
+// Suspension locks a user out of their account until Until, or until they
+// are reinstated if Until is nil.
+type Suspension struct {
+	Reason      string     `json:"reason"`
+	SuspendedBy int        `json:"suspended_by"`
+	SuspendedAt time.Time  `json:"suspended_at"`
+	Until       *time.Time `json:"until,omitempty"`
+	// HideChirps hides the user's chirps from everyone while the suspension
+	// is active.
+	HideChirps bool `json:"hide_chirps,omitempty"`
+}
+
+// Active reports whether s is in force at now. A nil suspension is never
+// active.
+func (s *Suspension) Active(now time.Time) bool {
+	if s == nil {
+		return false
+	}
+	return s.Until == nil || now.Before(*s.Until)
+}
+
+// SuspendUser replaces any suspension on userID with suspension.
+func (db *DB) SuspendUser(userID int, suspension Suspension) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		if !dbStructure.Users[suspension.SuspendedBy].Outranks(user) {
+			return ErrOutranked
+		}
+		user.Suspension = &suspension
+		dbStructure.Users[userID] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// ReinstateUser lifts any suspension on userID. Like suspending, it takes a
+// moderator who outranks the user.
+func (db *DB) ReinstateUser(userID, moderatorID int) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		if !dbStructure.Users[moderatorID].Outranks(user) {
+			return ErrOutranked
+		}
+		user.Suspension = nil
+		dbStructure.Users[userID] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// GetHiddenAuthors returns the users whose chirps are hidden by an active
+// suspension.
+func (db *DB) GetHiddenAuthors() (map[int]struct{}, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	return dbStructure.hiddenAuthors(time.Now().UTC()), nil
+}
+
+func (dbStructure DBStructure) hiddenAuthors(now time.Time) map[int]struct{} {
+	authors := map[int]struct{}{}
+	for id, user := range dbStructure.Users {
+		if user.Suspension.Active(now) && user.Suspension.HideChirps {
+			authors[id] = struct{}{}
+		}
+	}
+	return authors
+}
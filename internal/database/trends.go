@@ -0,0 +1,70 @@
+package database
+
+import "time"
+
+type TagSuppression struct {
+	Tag          string    `json:"tag"`
+	SuppressedAt time.Time `json:"suppressed_at"`
+}
+
+// GetChirpsSince returns every chirp created at or after since, leaving out
+// chirps hidden by moderators or by their author's suspension.
+func (db *DB) GetChirpsSince(since time.Time) ([]Chirp, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := dbStructure.hiddenAuthors(time.Now().UTC())
+	chirps := []Chirp{}
+	for _, chirp := range dbStructure.Chirps {
+		if _, ok := hidden[chirp.UserID]; ok || chirp.Hidden {
+			continue
+		}
+		if !chirp.CreatedAt.Before(since) {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	return chirps, nil
+}
+
+func (db *DB) SuppressTag(tag string) (TagSuppression, error) {
+	var suppression TagSuppression
+	err := db.update(func(dbStructure *DBStructure) error {
+		if existing, ok := dbStructure.SuppressedTags[tag]; ok {
+			suppression = existing
+			return nil
+		}
+		suppression = TagSuppression{
+			Tag:          tag,
+			SuppressedAt: time.Now().UTC(),
+		}
+		dbStructure.SuppressedTags[tag] = suppression
+		return nil
+	})
+	if err != nil {
+		return TagSuppression{}, err
+	}
+
+	return suppression, nil
+}
+
+func (db *DB) UnsuppressTag(tag string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.SuppressedTags[tag]; !ok {
+			return ErrNotExist
+		}
+		delete(dbStructure.SuppressedTags, tag)
+		return nil
+	})
+}
+
+func (db *DB) GetSuppressedTags() (map[string]TagSuppression, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	return dbStructure.SuppressedTags, nil
+}
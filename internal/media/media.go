@@ -0,0 +1,151 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	// MaxPixels caps decoded image size so a small, highly compressed upload
+	// can't exhaust memory once decoded.
+	MaxPixels     = 24_000_000
+	ThumbnailSize = 320
+	jpegQuality   = 90
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported media type")
+	ErrTooLarge        = errors.New("image dimensions too large")
+)
+
+type Image struct {
+	ContentType string
+	Data        []byte
+	Width       int
+	Height      int
+
+	ThumbnailContentType string
+	Thumbnail            []byte
+}
+
+// Process validates an uploaded image by sniffing its content, re-encodes it
+// to drop EXIF and other metadata, and renders a thumbnail.
+func Process(data []byte) (Image, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return Image{}, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, ErrUnsupportedType
+	}
+	if config.Width*config.Height > MaxPixels {
+		return Image{}, ErrTooLarge
+	}
+
+	// Encoding from the decoded pixels writes none of the original
+	// metadata blocks, which is what strips EXIF.
+	var first image.Image
+	buf := &bytes.Buffer{}
+	switch contentType {
+	case "image/jpeg":
+		first, err = jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return Image{}, ErrUnsupportedType
+		}
+		err = jpeg.Encode(buf, first, &jpeg.Options{Quality: jpegQuality})
+	case "image/png":
+		first, err = png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return Image{}, ErrUnsupportedType
+		}
+		err = png.Encode(buf, first)
+	case "image/gif":
+		var anim *gif.GIF
+		anim, err = gif.DecodeAll(bytes.NewReader(data))
+		if err != nil || len(anim.Image) == 0 {
+			return Image{}, ErrUnsupportedType
+		}
+		first = anim.Image[0]
+		err = gif.EncodeAll(buf, anim)
+	}
+	if err != nil {
+		return Image{}, err
+	}
+
+	thumb := &bytes.Buffer{}
+	thumbType := "image/png"
+	if contentType == "image/jpeg" {
+		thumbType = "image/jpeg"
+		err = jpeg.Encode(thumb, scaleDown(first, ThumbnailSize), &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(thumb, scaleDown(first, ThumbnailSize))
+	}
+	if err != nil {
+		return Image{}, err
+	}
+
+	return Image{
+		ContentType:          contentType,
+		Data:                 buf.Bytes(),
+		Width:                config.Width,
+		Height:               config.Height,
+		ThumbnailContentType: thumbType,
+		Thumbnail:            thumb.Bytes(),
+	}, nil
+}
+
+// scaleDown shrinks src so that neither side exceeds size, averaging the
+// source pixels that fall under each destination pixel. Images that already
+// fit are copied as-is.
+func scaleDown(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	dstW, dstH := srcW, srcH
+	if srcW > size || srcH > size {
+		if srcW >= srcH {
+			dstW, dstH = size, max(1, srcH*size/srcW)
+		} else {
+			dstW, dstH = max(1, srcW*size/srcH), size
+		}
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0 := bounds.Min.Y + y*srcH/dstH
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcH/dstH)
+		for x := 0; x < dstW; x++ {
+			x0 := bounds.Min.X + x*srcW/dstW
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcW/dstW)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
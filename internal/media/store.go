@@ -0,0 +1,74 @@
+package media
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"internal/blobstore"
+)
+
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Store keeps media in a blob store, addressed by the hex SHA-256 of their
+// contents and fanned out into prefixes by the first two characters.
+type Store struct {
+	blobs blobstore.Store
+}
+
+func NewStore(blobs blobstore.Store) *Store {
+	return &Store{blobs: blobs}
+}
+
+// Put writes data to the store and returns its key. Storing the same content
+// twice is a no-op.
+func (s *Store) Put(ctx context.Context, data []byte, contentType string) (string, error) {
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:])
+
+	ok, err := s.blobs.Exists(ctx, blobKey(key))
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return key, nil
+	}
+
+	err = s.blobs.Put(ctx, blobKey(key), data, contentType)
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Open returns the blob stored under key.
+func (s *Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	return s.blobs.Get(ctx, blobKey(key))
+}
+
+// PresignGet returns a direct download URL for key when the underlying blob
+// store supports it.
+func (s *Store) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	return s.blobs.PresignGet(ctx, blobKey(key), expires)
+}
+
+func blobKey(key string) string {
+	return key[:2] + "/" + key
+}
+
+func validKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
@@ -0,0 +1,3 @@
+module github.com/JIsaacSamuel/chirpy/internal/moderation
+
+go 1.21.5
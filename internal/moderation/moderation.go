@@ -0,0 +1,226 @@
+package moderation
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type Action string
+
+const (
+	ActionMask   Action = "mask"
+	ActionReject Action = "reject"
+	ActionFlag   Action = "flag"
+
+	mask = "****"
+)
+
+// RuleConfig is one rule as written in the rules file. A rule matches either
+// words, from Words and WordsFile, or a regular expression in Pattern.
+type RuleConfig struct {
+	Name      string   `json:"name"`
+	Action    Action   `json:"action"`
+	Words     []string `json:"words"`
+	WordsFile string   `json:"words_file"`
+	Pattern   string   `json:"pattern"`
+	Reason    string   `json:"reason"`
+}
+
+type Config struct {
+	Rules []RuleConfig `json:"rules"`
+}
+
+// DefaultConfig is used when no rules file exists.
+var DefaultConfig = Config{
+	Rules: []RuleConfig{
+		{
+			Name:   "profanity",
+			Action: ActionMask,
+			Words:  []string{"kerfuffle", "sharbert", "fornax"},
+		},
+	},
+}
+
+type rule struct {
+	name    string
+	action  Action
+	reason  string
+	words   map[string]struct{}
+	pattern *regexp.Regexp
+}
+
+// Pipeline applies an ordered list of rules to chirp bodies.
+type Pipeline struct {
+	rules []rule
+	// files lists every file the pipeline was built from, so a Reloader
+	// can tell when it is stale.
+	files []string
+}
+
+type Result struct {
+	Body     string
+	Rejected bool
+	Reason   string
+	// Flagged names the rules with the flag action that matched.
+	Flagged []string
+}
+
+// New compiles cfg. Relative word list paths are resolved against dir.
+func New(cfg Config, dir string) (*Pipeline, error) {
+	p := &Pipeline{}
+	for i, rc := range cfg.Rules {
+		if rc.Name == "" {
+			rc.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		switch rc.Action {
+		case ActionMask, ActionReject, ActionFlag:
+		default:
+			return nil, fmt.Errorf("%s: unknown action %q", rc.Name, rc.Action)
+		}
+
+		r := rule{
+			name:   rc.Name,
+			action: rc.Action,
+			reason: rc.Reason,
+		}
+		if rc.Pattern != "" {
+			if len(rc.Words) > 0 || rc.WordsFile != "" {
+				return nil, fmt.Errorf("%s: a rule can't have both words and a pattern", rc.Name)
+			}
+			pattern, err := regexp.Compile("(?i)" + rc.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", rc.Name, err)
+			}
+			r.pattern = pattern
+		} else {
+			words := append([]string{}, rc.Words...)
+			if rc.WordsFile != "" {
+				path := rc.WordsFile
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(dir, path)
+				}
+				fileWords, err := readWordList(path)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", rc.Name, err)
+				}
+				words = append(words, fileWords...)
+				p.files = append(p.files, path)
+			}
+			if len(words) == 0 {
+				return nil, fmt.Errorf("%s: rule has no words or pattern", rc.Name)
+			}
+			r.words = map[string]struct{}{}
+			for _, word := range words {
+				for _, form := range entryForms(word) {
+					r.words[form] = struct{}{}
+				}
+			}
+		}
+		p.rules = append(p.rules, r)
+	}
+	return p, nil
+}
+
+// Load reads a rules file. Missing files fall back to DefaultConfig.
+func Load(path string) (*Pipeline, error) {
+	dat, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return New(DefaultConfig, filepath.Dir(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := Config{}
+	err = json.Unmarshal(dat, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	p, err := New(cfg, filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	p.files = append(p.files, path)
+	return p, nil
+}
+
+// readWordList reads one word or phrase per line, skipping blank lines and
+// lines starting with '#'.
+func readWordList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+// Moderate runs body through every rule in order. Masking rules rewrite the
+// body seen by later rules; the first rejecting rule to match stops the
+// pipeline.
+func (p *Pipeline) Moderate(body string) Result {
+	result := Result{Body: body}
+	for _, r := range p.rules {
+		spans := r.match(result.Body)
+		if len(spans) == 0 {
+			continue
+		}
+
+		switch r.action {
+		case ActionReject:
+			result.Rejected = true
+			result.Reason = r.reason
+			if result.Reason == "" {
+				result.Reason = "Chirp violates the " + r.name + " policy"
+			}
+			return result
+		case ActionFlag:
+			result.Flagged = append(result.Flagged, r.name)
+		case ActionMask:
+			result.Body = maskSpans(result.Body, spans)
+		}
+	}
+	return result
+}
+
+func (r rule) match(body string) []span {
+	if r.pattern != nil {
+		spans := []span{}
+		for _, loc := range r.pattern.FindAllStringIndex(body, -1) {
+			if loc[1] > loc[0] {
+				spans = append(spans, span{start: loc[0], end: loc[1]})
+			}
+		}
+		return spans
+	}
+	return matchWords(body, r.words)
+}
+
+// maskSpans replaces each span of body with the mask. spans must be sorted
+// and not overlap.
+func maskSpans(body string, spans []span) string {
+	b := &strings.Builder{}
+	last := 0
+	for _, s := range spans {
+		b.WriteString(body[last:s.start])
+		b.WriteString(mask)
+		last = s.end
+	}
+	b.WriteString(body[last:])
+	return b.String()
+}
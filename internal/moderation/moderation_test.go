@@ -0,0 +1,129 @@
+package moderation
+
+import (
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		in          string
+		wantPlain   string
+		wantDecoded string
+	}{
+		{"Kerfuffle", "kerfuffle", "kerfuffle"},
+		{"kérfüffle", "kerfuffle", "kerfuffle"},
+		{"ｋｅｒｆｕｆｆｌｅ", "kerfuffle", "kerfuffle"},
+		{"ﬁne", "fine", "fine"},
+		{"k3rfuffl3", "k3rfuffl3", "kerfuffle"},
+		{"f0rn@x", "f0rnx", "fornax"},
+		{"$harb3r+", "harb3r", "sharbert"},
+		{"k.e.r.f", "kerf", "kerf"},
+	}
+	for _, tt := range tests {
+		plain, decoded := fold(tt.in)
+		if plain != tt.wantPlain || decoded != tt.wantDecoded {
+			t.Errorf("fold(%q) = %q, %q, want %q, %q", tt.in, plain, decoded, tt.wantPlain, tt.wantDecoded)
+		}
+	}
+}
+
+func TestTokenizeOffsets(t *testing.T) {
+	body := "«Kerfuffle!» fornax,sharbert"
+	tokens := tokenize(body)
+	if len(tokens) != 2 {
+		t.Fatalf("got %d tokens, want 2", len(tokens))
+	}
+	if got := body[tokens[0].start:tokens[0].end]; got != "Kerfuffle" {
+		t.Errorf("first token = %q, want %q", got, "Kerfuffle")
+	}
+	if got := body[tokens[0].full.start:tokens[0].full.end]; got != "Kerfuffle!" {
+		t.Errorf("first token full span = %q, want %q", got, "Kerfuffle!")
+	}
+	parts := tokens[1].parts
+	if len(parts) != 2 {
+		t.Fatalf("got %d parts, want 2", len(parts))
+	}
+	for i, want := range []string{"fornax", "sharbert"} {
+		if got := body[parts[i].start:parts[i].end]; got != want {
+			t.Errorf("part %d = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestModerateMask(t *testing.T) {
+	p, err := New(Config{Rules: []RuleConfig{{
+		Name:   "profane",
+		Action: ActionMask,
+		Words:  []string{"kerfuffle", "sharbert", "fornax", "ass", "big kerfuffle"},
+	}}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"plain", "what a kerfuffle today", "what a **** today"},
+		{"case", "KERFUFFLE", "****"},
+		{"accents", "such a kérfüffle", "such a ****"},
+		{"fullwidth", "ｋｅｒｆｕｆｆｌｅ!", "****!"},
+		{"leet", "k3rfuffl3 and f0rn@x", "**** and ****"},
+		{"leet at the edges", "you a$$", "you ****"},
+		{"trailing punctuation", "Kerfuffle! Sharbert?", "****! ****?"},
+		{"comma", "kerfuffle,fornax", "****,****"},
+		{"apostrophe", "kerfuffle's fault", "****'s fault"},
+		{"parenthesised", "(fornax)", "(****)"},
+		{"run together", "kerfufflekerfuffle", "****"},
+		{"run together with leet", "kerfuffle!fornax", "****"},
+		{"dotted", "k.e.r.f.u.f.f.l.e", "****"},
+		{"repeated letters", "fornaaaax", "****"},
+		{"phrase", "a big kerfuffle here", "a **** here"},
+		{"multibyte before match", "héllo wörld kerfuffle ✓", "héllo wörld **** ✓"},
+		{"embedded in a word", "kerfuffles and sharberts", "kerfuffles and sharberts"},
+		{"partial compound", "fornaxes", "fornaxes"},
+		{"clean", "nothing to see here", "nothing to see here"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := p.Moderate(tt.body)
+			if result.Body != tt.want {
+				t.Errorf("Moderate(%q) = %q, want %q", tt.body, result.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestModerateActions(t *testing.T) {
+	p, err := New(Config{Rules: []RuleConfig{
+		{Name: "spam", Action: ActionReject, Words: []string{"buy now"}, Reason: "spam"},
+		{Name: "watch", Action: ActionFlag, Words: []string{"fornax"}},
+	}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		rejected   bool
+		flagged    bool
+		wantReason string
+	}{
+		{name: "reject", body: "BUY, now!", rejected: true, wantReason: "spam"},
+		{name: "flag", body: "fornax's moons", flagged: true},
+		{name: "clean", body: "nothing here"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := p.Moderate(tt.body)
+			if result.Rejected != tt.rejected || result.Reason != tt.wantReason {
+				t.Errorf("rejected = %v (%q), want %v (%q)", result.Rejected, result.Reason, tt.rejected, tt.wantReason)
+			}
+			if (len(result.Flagged) > 0) != tt.flagged {
+				t.Errorf("flagged = %v, want %v", result.Flagged, tt.flagged)
+			}
+		})
+	}
+}
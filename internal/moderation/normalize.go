@@ -0,0 +1,310 @@
+package moderation
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxPhraseWords bounds how many consecutive tokens are joined when looking
+// for multi-word entries in a word list.
+const maxPhraseWords = 4
+
+// leet maps characters commonly substituted for letters back to them.
+var leet = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'8': 'b',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+	'|': 'l',
+	'+': 't',
+}
+
+type span struct {
+	start int
+	end   int
+}
+
+// token is a word from a chirp. The core is the word with surrounding
+// punctuation trimmed, so "Kerfuffle!" matches as "Kerfuffle". The full span
+// keeps leading and trailing leetspeak characters, so "a$$" can still match
+// as a whole.
+type token struct {
+	span
+	forms []string
+
+	full      span
+	fullForms []string
+
+	// parts splits a word at punctuation that isn't leetspeak, so the
+	// entries in "kerfuffle,fornax" or "kerfuffle's" are found on their
+	// own. It is empty when the word has only one part.
+	parts []token
+}
+
+// tokenize splits body into words on whitespace, and each word into parts at
+// any other rune that isn't a letter, digit or leetspeak substitute. Offsets
+// are byte offsets into body.
+func tokenize(body string) []token {
+	tokens := []token{}
+	for _, word := range splitSpans(body, 0, len(body), unicode.IsSpace) {
+		t, ok := newToken(body, word)
+		if !ok {
+			continue
+		}
+		for _, part := range splitSpans(body, word.start, word.end, isSeparator) {
+			if p, ok := newToken(body, part); ok {
+				t.parts = append(t.parts, p)
+			}
+		}
+		if len(t.parts) == 1 {
+			t.parts = nil
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens
+}
+
+func newToken(body string, word span) (token, bool) {
+	s, e := trim(body, word.start, word.end, isWordRune)
+	fs, fe := trim(body, word.start, word.end, isLeetOrWordRune)
+	if e <= s {
+		return token{}, false
+	}
+	forms := normalizedForms(body[s:e])
+	if len(forms) == 0 {
+		return token{}, false
+	}
+	return token{
+		span:      span{start: s, end: e},
+		forms:     forms,
+		full:      span{start: fs, end: fe},
+		fullForms: normalizedForms(body[fs:fe]),
+	}, true
+}
+
+// splitSpans returns the non-empty runs of body[start:end] between runes
+// that sep accepts.
+func splitSpans(body string, start, end int, sep func(rune) bool) []span {
+	spans := []span{}
+	from := -1
+	for i, r := range body[start:end] {
+		if sep(r) {
+			if from >= 0 {
+				spans = append(spans, span{start: start + from, end: start + i})
+				from = -1
+			}
+			continue
+		}
+		if from < 0 {
+			from = i
+		}
+	}
+	if from >= 0 {
+		spans = append(spans, span{start: start + from, end: end})
+	}
+	return spans
+}
+
+// trim narrows body[start:end] to begin and end on a rune that keep accepts.
+func trim(body string, start, end int, keep func(rune) bool) (int, int) {
+	word := body[start:end]
+	first := strings.IndexFunc(word, keep)
+	if first < 0 {
+		return start, start
+	}
+	last := strings.LastIndexFunc(word, keep)
+	_, size := utf8.DecodeRuneInString(word[last:])
+	return start + first, start + last + size
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+func isLeetOrWordRune(r rune) bool {
+	_, ok := leet[r]
+	return ok || isWordRune(r)
+}
+
+func isSeparator(r rune) bool {
+	return !isLeetOrWordRune(r)
+}
+
+// normalizedForms returns the spellings a word from a chirp is matched
+// under: folded to lower case with accents and compatibility characters
+// removed, with leetspeak decoded, and with long runs of a repeated letter
+// shortened. Punctuation that isn't leetspeak is dropped, so "k.e.r.f"
+// matches "kerf".
+func normalizedForms(word string) []string {
+	plain, decoded := fold(word)
+	return uniqueForms(plain, decoded, squeeze(decoded, 2), squeeze(decoded, 1))
+}
+
+// entryForms returns the spellings a word list entry is stored under. Runs
+// of letters aren't shortened, so that "good" never matches an entry "god".
+func entryForms(word string) []string {
+	plain, decoded := fold(word)
+	return uniqueForms(plain, decoded)
+}
+
+func fold(word string) (string, string) {
+	decomposed := norm.NFKD.String(word)
+
+	plain := &strings.Builder{}
+	decoded := &strings.Builder{}
+	for _, r := range decomposed {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+			plain.WriteRune(r)
+		}
+		if l, ok := leet[r]; ok {
+			decoded.WriteRune(l)
+		} else if unicode.IsLetter(r) || unicode.IsSpace(r) {
+			decoded.WriteRune(r)
+		}
+	}
+
+	return plain.String(), decoded.String()
+}
+
+func uniqueForms(candidates ...string) []string {
+	forms := []string{}
+	seen := map[string]struct{}{}
+	for _, form := range candidates {
+		form = strings.Join(strings.Fields(form), " ")
+		if _, ok := seen[form]; ok || form == "" {
+			continue
+		}
+		seen[form] = struct{}{}
+		forms = append(forms, form)
+	}
+	return forms
+}
+
+// squeeze shortens runs of three or more of the same letter to n, so
+// squeeze("fooornaaax", 1) is "fornax". Doubled letters are left alone.
+func squeeze(s string, n int) string {
+	runes := []rune(s)
+	b := &strings.Builder{}
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && runes[j] == runes[i] {
+			j++
+		}
+		count := j - i
+		if count >= 3 {
+			count = n
+		}
+		b.WriteString(strings.Repeat(string(runes[i]), count))
+		i = j
+	}
+	return b.String()
+}
+
+// matchWords finds the tokens, or runs of up to maxPhraseWords tokens, of
+// body that appear in words. A word that doesn't match as a whole is matched
+// part by part, and a word or part made up entirely of entries run together,
+// like "kerfufflekerfuffle", matches too.
+func matchWords(body string, words map[string]struct{}) []span {
+	tokens := tokenize(body)
+	spans := []span{}
+	for i := 0; i < len(tokens); i++ {
+		if containsAny(words, tokens[i].fullForms) && !containsAny(words, tokens[i].forms) {
+			spans = append(spans, tokens[i].full)
+			continue
+		}
+
+		matched := 0
+		for n := min(maxPhraseWords, len(tokens)-i); n >= 1; n-- {
+			if containsAny(words, phraseForms(tokens[i:i+n])) {
+				matched = n
+				break
+			}
+		}
+		if matched > 0 {
+			spans = append(spans, span{start: tokens[i].start, end: tokens[i+matched-1].end})
+			i += matched - 1
+			continue
+		}
+
+		found := len(spans)
+		for _, part := range tokens[i].parts {
+			switch {
+			case containsAny(words, part.fullForms) && !containsAny(words, part.forms):
+				spans = append(spans, part.full)
+			case containsAny(words, part.forms) || compoundAny(words, part.forms):
+				spans = append(spans, part.span)
+			}
+		}
+		if len(spans) == found && compoundAny(words, tokens[i].forms) {
+			spans = append(spans, tokens[i].span)
+		}
+	}
+	return spans
+}
+
+func containsAny(words map[string]struct{}, forms []string) bool {
+	for _, form := range forms {
+		if _, ok := words[form]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compoundAny reports whether any of forms is made up of one or more entries
+// in words with nothing between them. Phrases in words never match, since
+// forms have no spaces.
+func compoundAny(words map[string]struct{}, forms []string) bool {
+	for _, form := range forms {
+		if compound(words, form) {
+			return true
+		}
+	}
+	return false
+}
+
+func compound(words map[string]struct{}, form string) bool {
+	// splits[i] is set when form[:i] is a run of entries.
+	splits := make([]bool, len(form)+1)
+	splits[0] = true
+	for end := 1; end <= len(form); end++ {
+		for start := 0; start < end && !splits[end]; start++ {
+			if !splits[start] {
+				continue
+			}
+			if _, ok := words[form[start:end]]; ok {
+				splits[end] = true
+			}
+		}
+	}
+	return len(form) > 0 && splits[len(form)]
+}
+
+// phraseForms joins the normalized forms of tokens position by position.
+func phraseForms(tokens []token) []string {
+	if len(tokens) == 1 {
+		return tokens[0].forms
+	}
+	forms := []string{}
+	for f := 0; f < 4; f++ {
+		parts := []string{}
+		for _, t := range tokens {
+			parts = append(parts, t.forms[min(f, len(t.forms)-1)])
+		}
+		forms = append(forms, strings.Join(parts, " "))
+	}
+	return forms
+}
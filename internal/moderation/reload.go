@@ -0,0 +1,94 @@
+package moderation
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves the pipeline built from a rules file, rebuilding it when
+// the file or any word list it references changes on disk.
+type Reloader struct {
+	path string
+
+	mu       sync.RWMutex
+	pipeline *Pipeline
+	modTimes map[string]time.Time
+}
+
+func NewReloader(path string) (*Reloader, error) {
+	pipeline, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	rl := &Reloader{path: path}
+	rl.set(pipeline)
+	return rl, nil
+}
+
+func (rl *Reloader) Pipeline() *Pipeline {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return rl.pipeline
+}
+
+// Watch checks for changes every interval until the process exits. A rules
+// file that fails to load is logged and the previous pipeline kept.
+func (rl *Reloader) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !rl.changed() {
+			continue
+		}
+		pipeline, err := Load(rl.path)
+		if err != nil {
+			log.Printf("Couldn't reload moderation rules: %s", err)
+			// Remember what failed so the error is logged once per edit.
+			rl.mu.Lock()
+			rl.modTimes = rl.currentModTimes(rl.pipeline.files)
+			rl.mu.Unlock()
+			continue
+		}
+		rl.set(pipeline)
+		log.Printf("Reloaded moderation rules from %s", rl.path)
+	}
+}
+
+func (rl *Reloader) set(pipeline *Pipeline) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.pipeline = pipeline
+	rl.modTimes = rl.currentModTimes(pipeline.files)
+}
+
+func (rl *Reloader) changed() bool {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	current := rl.currentModTimes(rl.pipeline.files)
+	if len(current) != len(rl.modTimes) {
+		return true
+	}
+	for path, modTime := range current {
+		if !modTime.Equal(rl.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// currentModTimes stats the rules file and files. Missing files are left
+// out, so creating or deleting one counts as a change.
+func (rl *Reloader) currentModTimes(files []string) map[string]time.Time {
+	modTimes := map[string]time.Time{}
+	for _, path := range append([]string{rl.path}, files...) {
+		info, err := os.Stat(path)
+		if err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+	return modTimes
+}
@@ -0,0 +1,3 @@
+module github.com/JIsaacSamuel/chirpy/internal/plugins
+
+go 1.21.5
@@ -0,0 +1,267 @@
+// Package plugins runs chirp moderation filters compiled to WebAssembly.
+//
+// A plugin is a .wasm file exporting its linear memory as "memory" and two
+// functions:
+//
+//	alloc(size i32) -> i32
+//	moderate(ptr i32, len i32) -> i64
+//
+// The host calls alloc to reserve space for a JSON-encoded Input, writes it
+// there, and calls moderate with its location. moderate returns the location
+// of a JSON-encoded Output, packed as ptr<<32 | len. Plugins may import WASI
+// (wasi_snapshot_preview1) but are given no filesystem, network or clock
+// beyond what WASI provides by default.
+//
+// A plugin can be tuned by a JSON file next to it with the same base name,
+// e.g. spam.json for spam.wasm, holding a Limits object.
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+type Action string
+
+const (
+	ActionAllow  Action = "allow"
+	ActionMask   Action = "mask"
+	ActionReject Action = "reject"
+
+	wasmPageSize = 64 << 10
+)
+
+type Author struct {
+	ID          int    `json:"id"`
+	Email       string `json:"email"`
+	IsChirpyRed bool   `json:"is_chirpy_red"`
+}
+
+type Input struct {
+	Body   string `json:"body"`
+	Author Author `json:"author"`
+}
+
+type Output struct {
+	Action Action `json:"action"`
+	// Body is the replacement body when Action is mask.
+	Body   string `json:"body"`
+	Reason string `json:"reason"`
+}
+
+// Limits bound what a single call into a plugin may use.
+type Limits struct {
+	TimeoutMillis int `json:"timeout_ms"`
+	MemoryMiB     int `json:"memory_mib"`
+	// FailClosed rejects chirps when the plugin errors or times out,
+	// instead of skipping it.
+	FailClosed bool `json:"fail_closed"`
+}
+
+var DefaultLimits = Limits{
+	TimeoutMillis: 50,
+	MemoryMiB:     64,
+}
+
+type plugin struct {
+	name     string
+	limits   Limits
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+}
+
+// Chain runs plugins in file name order.
+type Chain struct {
+	plugins []*plugin
+}
+
+// LoadDir compiles every .wasm file in dir. A missing directory yields an
+// empty chain.
+func LoadDir(ctx context.Context, dir string) (*Chain, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Chain{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".wasm") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	chain := &Chain{}
+	for _, name := range names {
+		p, err := load(ctx, filepath.Join(dir, name))
+		if err != nil {
+			chain.Close(ctx)
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		chain.plugins = append(chain.plugins, p)
+	}
+	return chain, nil
+}
+
+func load(ctx context.Context, path string) (*plugin, error) {
+	limits := DefaultLimits
+	dat, err := os.ReadFile(strings.TrimSuffix(path, ".wasm") + ".json")
+	if err == nil {
+		err = json.Unmarshal(dat, &limits)
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if limits.TimeoutMillis <= 0 || limits.MemoryMiB <= 0 {
+		return nil, errors.New("timeout_ms and memory_mib must be positive")
+	}
+
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each plugin gets its own runtime so its memory limit is its own, and
+	// so a call is aborted as soon as its context expires.
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(limits.MemoryMiB*(1<<20)/wasmPageSize)).
+		WithCloseOnContextDone(true))
+	_, err = wasi_snapshot_preview1.Instantiate(ctx, runtime)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	for _, export := range []string{"alloc", "moderate"} {
+		if _, ok := compiled.ExportedFunctions()[export]; !ok {
+			runtime.Close(ctx)
+			return nil, fmt.Errorf("missing export %q", export)
+		}
+	}
+
+	return &plugin{
+		name:     strings.TrimSuffix(filepath.Base(path), ".wasm"),
+		limits:   limits,
+		runtime:  runtime,
+		compiled: compiled,
+	}, nil
+}
+
+func (c *Chain) Len() int {
+	return len(c.plugins)
+}
+
+func (c *Chain) Close(ctx context.Context) error {
+	var errs []error
+	for _, p := range c.plugins {
+		errs = append(errs, p.runtime.Close(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Run passes input through each plugin in turn. A mask replaces the body
+// seen by later plugins; the first reject stops the chain and is returned.
+func (c *Chain) Run(ctx context.Context, input Input) Output {
+	result := Output{Action: ActionAllow, Body: input.Body}
+	for _, p := range c.plugins {
+		input.Body = result.Body
+		out, err := p.call(ctx, input)
+		if err != nil {
+			log.Printf("Moderation plugin %s failed: %s", p.name, err)
+			if p.limits.FailClosed {
+				return Output{
+					Action: ActionReject,
+					Reason: "Chirp couldn't be checked, try again later",
+				}
+			}
+			continue
+		}
+
+		switch out.Action {
+		case ActionReject:
+			if out.Reason == "" {
+				out.Reason = "Chirp rejected by " + p.name
+			}
+			return out
+		case ActionMask:
+			result.Action = ActionMask
+			result.Body = out.Body
+		}
+	}
+	return result
+}
+
+// call runs one moderate call in a fresh instance of the plugin, so no state
+// carries over between chirps.
+func (p *plugin) call(ctx context.Context, input Input) (Output, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(p.limits.TimeoutMillis)*time.Millisecond)
+	defer cancel()
+
+	mod, err := p.runtime.InstantiateModule(ctx, p.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize"))
+	if err != nil {
+		return Output{}, err
+	}
+	defer mod.Close(ctx)
+
+	dat, err := json.Marshal(input)
+	if err != nil {
+		return Output{}, err
+	}
+
+	res, err := mod.ExportedFunction("alloc").Call(ctx, uint64(len(dat)))
+	if err != nil {
+		return Output{}, err
+	}
+	ptr := uint32(res[0])
+	if !mod.Memory().Write(ptr, dat) {
+		return Output{}, errors.New("alloc returned memory out of range")
+	}
+
+	res, err = mod.ExportedFunction("moderate").Call(ctx, uint64(ptr), uint64(len(dat)))
+	if err != nil {
+		return Output{}, err
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	outDat, ok := mod.Memory().Read(outPtr, outLen)
+	if !ok {
+		return Output{}, errors.New("moderate returned memory out of range")
+	}
+
+	out := Output{}
+	err = json.Unmarshal(outDat, &out)
+	if err != nil {
+		return Output{}, err
+	}
+	switch out.Action {
+	case ActionAllow, ActionReject:
+	case ActionMask:
+		if out.Body == "" {
+			return Output{}, errors.New("mask without a body")
+		}
+	default:
+		return Output{}, fmt.Errorf("unknown action %q", out.Action)
+	}
+	return out, nil
+}
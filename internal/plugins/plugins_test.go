@@ -0,0 +1,116 @@
+package plugins
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadFilter loads testdata/filter.wasm, built from filter.wat, into a chain
+// of its own with the given limits.
+func loadFilter(t *testing.T, limits string) *Chain {
+	t.Helper()
+	code, err := os.ReadFile(filepath.Join("testdata", "filter.wasm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	err = os.WriteFile(filepath.Join(dir, "filter.wasm"), code, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits != "" {
+		err = os.WriteFile(filepath.Join(dir, "filter.json"), []byte(limits), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chain, err := LoadDir(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Close(context.Background()) })
+	if chain.Len() != 1 {
+		t.Fatalf("loaded %d plugins, want 1", chain.Len())
+	}
+	return chain
+}
+
+func TestChainRun(t *testing.T) {
+	failOpen := `{"timeout_ms": 50, "memory_mib": 1}`
+	failClosed := `{"timeout_ms": 50, "memory_mib": 1, "fail_closed": true}`
+
+	tests := []struct {
+		name   string
+		limits string
+		body   string
+		want   Output
+	}{
+		{
+			name: "allow",
+			body: "a fine chirp",
+			want: Output{Action: ActionAllow, Body: "a fine chirp"},
+		},
+		{
+			name: "mask",
+			body: "mask me",
+			want: Output{Action: ActionMask, Body: "masked"},
+		},
+		{
+			name: "mask longer than the chirp",
+			body: "long",
+			want: Output{Action: ActionMask, Body: strings.Repeat("x", 150)},
+		},
+		{
+			name: "reject",
+			body: "rejected",
+			want: Output{Action: ActionReject, Reason: "nope"},
+		},
+		{
+			name:   "timeout fails open",
+			limits: failOpen,
+			body:   "spin",
+			want:   Output{Action: ActionAllow, Body: "spin"},
+		},
+		{
+			name:   "trap fails open",
+			limits: failOpen,
+			body:   "trap",
+			want:   Output{Action: ActionAllow, Body: "trap"},
+		},
+		{
+			name:   "timeout fails closed",
+			limits: failClosed,
+			body:   "spin",
+			want:   Output{Action: ActionReject, Reason: "Chirp couldn't be checked, try again later"},
+		},
+		{
+			name:   "trap fails closed",
+			limits: failClosed,
+			body:   "trap",
+			want:   Output{Action: ActionReject, Reason: "Chirp couldn't be checked, try again later"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := loadFilter(t, tt.limits)
+			got := chain.Run(context.Background(), Input{Body: tt.body})
+			if got != tt.want {
+				t.Errorf("Run(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadDirMissing(t *testing.T) {
+	chain, err := LoadDir(context.Background(), filepath.Join(t.TempDir(), "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain.Len() != 0 {
+		t.Errorf("loaded %d plugins from a missing directory", chain.Len())
+	}
+}
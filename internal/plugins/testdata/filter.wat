@@ -0,0 +1,35 @@
+;; filter.wasm is this module. It answers by the first byte of the chirp
+;; body, which sits right after the {"body":" that starts the input:
+;;
+;;	a  allow
+;;	m  mask as "masked"
+;;	r  reject with reason "nope"
+;;	l  mask with 150 x's
+;;	s  loop forever
+;;
+;; and traps on anything else.
+(module
+  (memory (export "memory") 1)
+
+  (data (i32.const 16) "{\"action\":\"allow\"}")
+  (data (i32.const 64) "{\"action\":\"mask\",\"body\":\"masked\"}")
+  (data (i32.const 128) "{\"action\":\"reject\",\"reason\":\"nope\"}")
+  (data (i32.const 256) "{\"action\":\"mask\",\"body\":\"xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx\"}")
+
+  (func (export "alloc") (param i32) (result i32)
+    i32.const 1024)
+
+  (func (export "moderate") (param $ptr i32) (param $len i32) (result i64)
+    (local $c i32)
+    (local.set $c (i32.load8_u offset=9 (local.get $ptr)))
+    (if (i32.eq (local.get $c) (i32.const 97))
+      (then (return (i64.const 0x10_00000012))))
+    (if (i32.eq (local.get $c) (i32.const 109))
+      (then (return (i64.const 0x40_00000021))))
+    (if (i32.eq (local.get $c) (i32.const 114))
+      (then (return (i64.const 0x80_00000023))))
+    (if (i32.eq (local.get $c) (i32.const 108))
+      (then (return (i64.const 0x100_000000b2))))
+    (if (i32.eq (local.get $c) (i32.const 115))
+      (then (loop $spin (br $spin))))
+    unreachable))
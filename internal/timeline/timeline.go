@@ -0,0 +1,293 @@
+package timeline
+
+import (
+	"sort"
+	"sync"
+
+	"internal/database"
+)
+
+const (
+	DefaultInboxSize       = 800
+	DefaultFanoutThreshold = 10000
+)
+
+// Service keeps an in-memory inbox of chirp IDs for each user's home
+// timeline. New chirps are pushed into their followers' inboxes as they are
+// written, except for authors with more than FanoutThreshold followers,
+// whose chirps are pulled in when a timeline is read instead.
+type Service struct {
+	db *database.DB
+
+	InboxSize       int
+	FanoutThreshold int
+
+	mu      sync.Mutex
+	inboxes map[int]*inbox
+	builds  map[int]*build
+}
+
+// inbox holds chirp IDs newest first. truncated is set once older entries
+// have been dropped to stay within the size limit.
+type inbox struct {
+	ids       []int
+	truncated bool
+}
+
+// build tracks the inbox reads in progress for one user. dirty is set when
+// the inbox changes while they read the database, as what they read may
+// already be out of date.
+type build struct {
+	readers int
+	dirty   bool
+}
+
+func New(db *database.DB) *Service {
+	return &Service{
+		db:              db,
+		InboxSize:       DefaultInboxSize,
+		FanoutThreshold: DefaultFanoutThreshold,
+		inboxes:         map[int]*inbox{},
+		builds:          map[int]*build{},
+	}
+}
+
+// ChirpCreated fans chirp out to its author's followers.
+func (s *Service) ChirpCreated(chirp database.Chirp) error {
+	followers, err := s.db.GetFollowers(chirp.UserID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.push(chirp.UserID, chirp.ID)
+	if len(followers) > s.FanoutThreshold {
+		return nil
+	}
+	for _, follow := range followers {
+		s.push(follow.FollowerID, chirp.ID)
+	}
+	return nil
+}
+
+// ChirpDeleted removes chirp from every inbox it was pushed to.
+func (s *Service) ChirpDeleted(chirp database.Chirp) error {
+	followers, err := s.db.GetFollowers(chirp.UserID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(chirp.UserID, chirp.ID)
+	for _, follow := range followers {
+		s.remove(follow.FollowerID, chirp.ID)
+	}
+	return nil
+}
+
+// FollowsChanged drops userID's inbox after they follow or unfollow someone.
+// It is rebuilt from the database on the next read.
+func (s *Service) FollowsChanged(userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.inboxes, userID)
+	s.markDirty(userID)
+}
+
+// Timeline returns up to limit chirp IDs for userID's home timeline, newest
+// first, starting below beforeID. A beforeID of 0 starts from the newest.
+func (s *Service) Timeline(userID, beforeID, limit int) ([]int, error) {
+	following, err := s.db.GetFollowing(userID)
+	if err != nil {
+		return nil, err
+	}
+	followeeIDs := []int{}
+	for _, follow := range following {
+		followeeIDs = append(followeeIDs, follow.FolloweeID)
+	}
+	followerCounts, err := s.db.GetFollowerCounts(followeeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	pushed := []int{userID}
+	pulled := []int{}
+	for _, id := range followeeIDs {
+		if followerCounts[id] > s.FanoutThreshold {
+			pulled = append(pulled, id)
+		} else {
+			pushed = append(pushed, id)
+		}
+	}
+
+	ids, ok, err := s.fromInbox(userID, pushed, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// The page reaches past what the inbox kept, so read everything
+		// from the database.
+		pulled = append(pulled, pushed...)
+		ids = nil
+	}
+
+	if len(pulled) > 0 {
+		chirps, err := s.db.GetChirpsByAuthors(pulled, beforeID, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, chirp := range chirps {
+			ids = append(ids, chirp.ID)
+		}
+	}
+
+	return mergeIDs(ids, limit), nil
+}
+
+// fromInbox reads a page from userID's inbox, building the inbox first if it
+// isn't cached. It reports false if the inbox can't answer the page alone.
+func (s *Service) fromInbox(userID int, authorIDs []int, beforeID, limit int) ([]int, bool, error) {
+	s.mu.Lock()
+	box, ok := s.inboxes[userID]
+	if !ok {
+		s.startBuild(userID)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		var err error
+		box, err = s.buildInbox(userID, authorIDs)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page := []int{}
+	for _, id := range box.ids {
+		if beforeID > 0 && id >= beforeID {
+			continue
+		}
+		page = append(page, id)
+		if len(page) == limit {
+			return page, true, nil
+		}
+	}
+	return page, !box.truncated, nil
+}
+
+// buildInbox reads userID's inbox from the database and caches it, unless
+// the inbox changed during the read. A chirp pushed then would be missing
+// from the cached inbox for good, so such an inbox only answers this read
+// and the next read builds it again.
+func (s *Service) buildInbox(userID int, authorIDs []int) (*inbox, error) {
+	chirps, err := s.db.GetChirpsByAuthors(authorIDs, 0, s.InboxSize+1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dirty := s.finishBuild(userID)
+	if err != nil {
+		return nil, err
+	}
+	// Another request may have built it in the meantime, and it has been
+	// kept up to date since.
+	if existing, ok := s.inboxes[userID]; ok {
+		return existing, nil
+	}
+
+	box := &inbox{ids: []int{}}
+	for _, chirp := range chirps {
+		box.ids = append(box.ids, chirp.ID)
+	}
+	if len(box.ids) > s.InboxSize {
+		box.ids = box.ids[:s.InboxSize]
+		box.truncated = true
+	}
+	if !dirty {
+		s.inboxes[userID] = box
+	}
+	return box, nil
+}
+
+// startBuild and finishBuild bracket a database read for userID's inbox.
+// finishBuild reports whether the inbox changed in between. Both must be
+// called with s.mu held.
+func (s *Service) startBuild(userID int) {
+	b, ok := s.builds[userID]
+	if !ok {
+		b = &build{}
+		s.builds[userID] = b
+	}
+	b.readers++
+}
+
+func (s *Service) finishBuild(userID int) bool {
+	b := s.builds[userID]
+	b.readers--
+	if b.readers == 0 {
+		delete(s.builds, userID)
+	}
+	return b.dirty
+}
+
+// markDirty records that userID's inbox changed while it isn't cached.
+func (s *Service) markDirty(userID int) {
+	if b, ok := s.builds[userID]; ok {
+		b.dirty = true
+	}
+}
+
+// push adds chirpID to userID's inbox if that inbox is cached. Uncached
+// inboxes are built from the database when first read.
+func (s *Service) push(userID, chirpID int) {
+	box, ok := s.inboxes[userID]
+	if !ok {
+		s.markDirty(userID)
+		return
+	}
+	box.ids = append(box.ids, 0)
+	copy(box.ids[1:], box.ids)
+	box.ids[0] = chirpID
+	if len(box.ids) > s.InboxSize {
+		box.ids = box.ids[:s.InboxSize]
+		box.truncated = true
+	}
+}
+
+func (s *Service) remove(userID, chirpID int) {
+	box, ok := s.inboxes[userID]
+	if !ok {
+		s.markDirty(userID)
+		return
+	}
+	for i, id := range box.ids {
+		if id == chirpID {
+			box.ids = append(box.ids[:i], box.ids[i+1:]...)
+			return
+		}
+	}
+}
+
+// mergeIDs sorts ids newest first, drops duplicates and keeps at most limit.
+func mergeIDs(ids []int, limit int) []int {
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	merged := []int{}
+	for i, id := range ids {
+		if i > 0 && id == ids[i-1] {
+			continue
+		}
+		merged = append(merged, id)
+		if len(merged) == limit {
+			break
+		}
+	}
+	return merged
+}
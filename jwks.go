@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+
+	"internal/auth"
+)
+
+// handlerJWKS publishes the public keys access tokens may be signed with, so
+// other services can verify them without sharing a secret.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Keys []auth.JWK `json:"keys"`
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, response{
+		Keys: cfg.Keys.JWKS(),
+	})
+}
@@ -1,19 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"internal/audit"
 	"internal/auth"
 	"internal/database"
+	"internal/media"
+	"internal/moderation"
+	"internal/plugins"
+	"internal/timeline"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/joho/godotenv"
@@ -21,35 +28,113 @@ import (
 )
 
 type Chirp struct {
-	AuthID int    `json:"author_id"`
-	Body   string `json:"body"`
-	ID     int    `json:"id"`
+	AuthID    int            `json:"author_id"`
+	Body      string         `json:"body"`
+	ID        int            `json:"id"`
+	Reactions map[string]int `json:"reactions"`
+
+	Hashtags []database.Hashtag `json:"hashtags"`
+	Mentions []database.Mention `json:"mentions"`
+	Media    []Attachment       `json:"media"`
+
+	Filtered *FilterAnnotation `json:"filtered,omitempty"`
 }
 
 type User struct {
 	EmailID      string `json:"email"`
 	ID           int    `json:"id"`
 	Subscription bool   `json:"is_chirpy_red"`
+	Role         string `json:"role,omitempty"`
 }
 
 type apiConfig struct {
 	fileserverHits int
 	DB             *database.DB
-	SecSig         string
+	Keys           *auth.Keyring
+	OIDC           *auth.OIDCProvider
+	Tokens         tokenConfig
 	RevokeDB       map[string]time.Time
 	PolkaKey       string
+	Trends         *trendCache
+	Media          *media.Store
+	Timelines      *timeline.Service
+	Moderation     *moderation.Reloader
+	Plugins        *plugins.Chain
+	Audit          *audit.Log
 }
 
 func main() {
 	const filepathRoot = "./static/"
 	const port = "8080"
 
+	godotenv.Load()
+	if len(os.Args) > 1 && os.Args[1] == "migrate-blobs" {
+		err := runMigrateBlobs(os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	db, err := database.NewDB("database.json")
 	if err != nil {
 		log.Fatal(err)
 	}
-	godotenv.Load()
-	jwtSecret := os.Getenv("JWTSECRET")
+	auditPath := os.Getenv("AUDIT_LOG")
+	if auditPath == "" {
+		auditPath = "audit.log"
+	}
+	auditKey := os.Getenv("AUDIT_HMAC_KEY")
+	if auditKey == "" {
+		log.Printf("AUDIT_HMAC_KEY isn't set, so anyone who can write %s can rewrite the audit log undetected", auditPath)
+	}
+	auditLog, err := audit.Open(auditPath, []byte(auditKey))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "create-admin" {
+		err := runCreateAdmin(db, auditLog, os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	blobs, err := newBlobStore(os.Getenv("BLOB_STORE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	rulesPath := os.Getenv("MODERATION_RULES")
+	if rulesPath == "" {
+		rulesPath = "moderation/rules.json"
+	}
+	moderator, err := moderation.NewReloader(rulesPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pluginDir := os.Getenv("PLUGIN_DIR")
+	if pluginDir == "" {
+		pluginDir = "plugins"
+	}
+	chain, err := plugins.LoadDir(context.Background(), pluginDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Loaded %d moderation plugins from %s", chain.Len(), pluginDir)
+	tokens, err := loadTokenConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys := auth.NewHMACKeyring(os.Getenv("JWTSECRET"))
+	if keyringPath := os.Getenv("JWT_KEYRING"); keyringPath != "" {
+		keys, err = auth.LoadKeyring(keyringPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	oidcProvider, err := loadOIDCProvider()
+	if err != nil {
+		log.Fatal(err)
+	}
 	// polkaKey := os.Getenv("POLKAKEY")
 
 	req := make(map[string]time.Time)
@@ -57,9 +142,23 @@ func main() {
 	apiCfg := apiConfig{
 		fileserverHits: 0,
 		DB:             db,
-		SecSig:         jwtSecret,
+		Keys:           keys,
+		OIDC:           oidcProvider,
+		Tokens:         tokens,
 		RevokeDB:       req,
 		PolkaKey:       "f271c81ff7084ee5b99a5091b42d486e",
+		Trends:         &trendCache{},
+		Media:          media.NewStore(blobs),
+		Timelines:      timeline.New(db),
+		Moderation:     moderator,
+		Plugins:        chain,
+		Audit:          auditLog,
+	}
+	if count, err := auditLog.Verify(); err != nil {
+		log.Printf("Audit log failed verification after %d entries: %s", count, err)
+	}
+	if threshold, err := strconv.Atoi(os.Getenv("TIMELINE_FANOUT_THRESHOLD")); err == nil {
+		apiCfg.Timelines.FanoutThreshold = threshold
 	}
 
 	router := chi.NewRouter()
@@ -69,21 +168,125 @@ func main() {
 
 	apiRouter := chi.NewRouter()
 	apiRouter.Get("/healthz", handlerReadiness)
-	apiRouter.Get("/reset", apiCfg.handlerReset)
-	apiRouter.Post("/chirps", apiCfg.handlerChirpsCreate)
-	apiRouter.Get("/chirps", apiCfg.handlerChirpsRetrieve)
-	apiRouter.Get("/chirps/{chirpsID}", apiCfg.handlerChirpsRetrieveID)
-	apiRouter.Delete("/chirps/{chirpsID}", apiCfg.handlerChirpsDelete)
-	apiRouter.Post("/users", apiCfg.handlerUserCreate)
-	apiRouter.Post("/login", apiCfg.handlerUserValidate)
-	apiRouter.Post("/refresh", apiCfg.handlerRefresh)
-	apiRouter.Post("/revoke", apiCfg.handlerRevoke)
-	apiRouter.Put("/users", apiCfg.handlerUserUpdate)
-	apiRouter.Post("/polka/webhooks", apiCfg.handlerPolkaWebhook)
+	apiRouter.Get("/trends", apiCfg.handlerTrendsRetrieve)
+	apiRouter.With(apiCfg.middlewareRequirePermission(permMetricsReset)).Get("/reset", apiCfg.handlerReset)
+	apiRouter.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRequireAuth)
+		r.Group(func(r chi.Router) {
+			r.Use(middlewareRequireScope(scopeChirpsWrite))
+			r.Post("/chirps", apiCfg.handlerChirpsCreate)
+			r.Delete("/chirps/{chirpsID}", apiCfg.handlerChirpsDelete)
+			r.Post("/chirps/{chirpsID}/reactions", apiCfg.handlerReactionsCreate)
+			r.Delete("/chirps/{chirpsID}/reactions", apiCfg.handlerReactionsDelete)
+			r.Post("/chirps/{chirpsID}/reports", apiCfg.handlerReportsCreate)
+			r.Post("/media", apiCfg.handlerMediaUpload)
+		})
+		r.With(middlewareRequireScope(scopeChirpsRead)).Get("/timeline", apiCfg.handlerTimelineRetrieve)
+		r.Group(func(r chi.Router) {
+			r.Use(middlewareRequireScope(scopeUsersRead))
+			r.Get("/blocks", apiCfg.handlerBlocksRetrieve)
+			r.Get("/mutes", apiCfg.handlerMutesRetrieve)
+			r.Get("/filters", apiCfg.handlerFiltersRetrieve)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(middlewareRequireScope(scopeUsersWrite))
+			r.Post("/users/{userID}/follow", apiCfg.handlerFollowCreate)
+			r.Delete("/users/{userID}/follow", apiCfg.handlerFollowDelete)
+			r.Post("/users/{userID}/block", apiCfg.handlerBlockCreate)
+			r.Delete("/users/{userID}/block", apiCfg.handlerBlockDelete)
+			r.Post("/users/{userID}/mute", apiCfg.handlerMuteCreate)
+			r.Delete("/users/{userID}/mute", apiCfg.handlerMuteDelete)
+			r.Post("/filters", apiCfg.handlerFiltersCreate)
+			r.Delete("/filters/{filterID}", apiCfg.handlerFiltersDelete)
+		})
+		// Credentials can only be managed from a login session.
+		r.Group(func(r chi.Router) {
+			r.Use(middlewareRequireSession)
+			r.Put("/users", apiCfg.handlerUserUpdate)
+			r.Get("/sessions", apiCfg.handlerSessionsRetrieve)
+			r.Delete("/sessions", apiCfg.handlerSessionsDelete)
+			r.Delete("/sessions/{sessionID}", apiCfg.handlerSessionDelete)
+			r.Get("/tokens", apiCfg.handlerPersonalTokensRetrieve)
+			r.Post("/tokens", apiCfg.handlerPersonalTokensCreate)
+			r.Delete("/tokens/{tokenID}", apiCfg.handlerPersonalTokenDelete)
+			r.Get("/oauth/clients", apiCfg.handlerOAuthClientsRetrieve)
+			r.Post("/oauth/clients", apiCfg.handlerOAuthClientsCreate)
+			r.Delete("/oauth/clients/{clientID}", apiCfg.handlerOAuthClientDelete)
+		})
+	})
+	apiRouter.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareOptionalAuth)
+		r.Group(func(r chi.Router) {
+			r.Use(middlewareRequireScope(scopeChirpsRead))
+			r.Get("/chirps", apiCfg.handlerChirpsRetrieve)
+			r.Get("/chirps/{chirpsID}", apiCfg.handlerChirpsRetrieveID)
+			r.Get("/chirps/{chirpsID}/reactions", apiCfg.handlerReactionsRetrieve)
+			r.Get("/tags/{tag}/chirps", apiCfg.handlerTagChirpsRetrieve)
+			r.Get("/users/{userID}/mentions", apiCfg.handlerMentionsRetrieve)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(middlewareRequireScope(scopeUsersRead))
+			r.Get("/users/{userID}/followers", apiCfg.handlerFollowersRetrieve)
+			r.Get("/users/{userID}/following", apiCfg.handlerFollowingRetrieve)
+		})
+	})
+	// These routes take a refresh token or API key in the Authorization
+	// header, or no credentials at all.
+	apiRouter.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareForbidAuth)
+		r.Post("/users", apiCfg.handlerUserCreate)
+		r.Post("/login", apiCfg.handlerUserValidate)
+		r.Get("/login/oidc", apiCfg.handlerOIDCLogin)
+		r.Get("/login/oidc/callback", apiCfg.handlerOIDCCallback)
+		r.Post("/refresh", apiCfg.handlerRefresh)
+		r.Post("/revoke", apiCfg.handlerRevoke)
+		r.Post("/polka/webhooks", apiCfg.handlerPolkaWebhook)
+	})
 	router.Mount("/api", apiRouter)
+	router.Get("/media/{key}", apiCfg.handlerMediaServe)
+	router.Get("/.well-known/jwks.json", apiCfg.handlerJWKS)
+	router.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareForbidAuth)
+		r.Get("/oauth/authorize", apiCfg.handlerOAuthAuthorize)
+		r.Post("/oauth/authorize", apiCfg.handlerOAuthConsent)
+		r.Post("/oauth/token", apiCfg.handlerOAuthToken)
+	})
 
 	adminRouter := chi.NewRouter()
-	adminRouter.Get("/metrics", apiCfg.handlerMetrics)
+	adminRouter.With(apiCfg.middlewareRequirePermission(permMetricsRead)).Get("/metrics", apiCfg.handlerMetrics)
+	adminRouter.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRequirePermission(permTrendsManage))
+		r.Get("/trends/suppressions", apiCfg.handlerTrendSuppressionsRetrieve)
+		r.Post("/trends/suppressions", apiCfg.handlerTrendSuppressionsCreate)
+		r.Delete("/trends/suppressions/{tag}", apiCfg.handlerTrendSuppressionsDelete)
+	})
+	adminRouter.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRequirePermission(permReportsManage))
+		r.Get("/reports", apiCfg.handlerReportsRetrieve)
+		r.Post("/reports/{reportID}/claim", apiCfg.handlerReportClaim)
+		r.Post("/reports/{reportID}/resolve", apiCfg.handlerReportResolve)
+	})
+	adminRouter.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRequirePermission(permUsersSuspend))
+		r.Post("/users/{userID}/suspension", apiCfg.handlerSuspensionCreate)
+		r.Delete("/users/{userID}/suspension", apiCfg.handlerSuspensionDelete)
+	})
+	adminRouter.With(apiCfg.middlewareRequirePermission(permRolesManage)).Put("/users/{userID}/role", apiCfg.handlerUserRoleUpdate)
+	adminRouter.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRequirePermission(permUsersManage))
+		r.Get("/users", apiCfg.handlerAdminUsersRetrieve)
+		r.Get("/users/{userID}", apiCfg.handlerAdminUserRetrieve)
+		r.Post("/users/{userID}/password-reset", apiCfg.handlerAdminPasswordReset)
+		r.Put("/users/{userID}/email", apiCfg.handlerAdminEmailUpdate)
+		r.Put("/users/{userID}/chirpy-red", apiCfg.handlerAdminSubscriptionUpdate)
+		r.Delete("/users/{userID}", apiCfg.handlerAdminUserDelete)
+	})
+	adminRouter.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRequirePermission(permAuditRead))
+		r.Get("/audit", apiCfg.handlerAuditRetrieve)
+		r.Get("/audit/export", apiCfg.handlerAuditExport)
+		r.Get("/audit/verify", apiCfg.handlerAuditVerify)
+	})
 	router.Mount("/admin", adminRouter)
 
 	corsMux := middlewareCors(router)
@@ -93,6 +296,9 @@ func main() {
 		Handler: corsMux,
 	}
 
+	go apiCfg.runTrends(time.Minute)
+	go apiCfg.Moderation.Watch(5 * time.Second)
+
 	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
 	log.Fatal(srv.ListenAndServe())
 }
@@ -180,13 +386,28 @@ func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
-	chirps := []Chirp{}
-	for _, dbChirp := range dbChirps {
-		chirps = append(chirps, Chirp{
-			ID:     dbChirp.ID,
-			Body:   dbChirp.Body,
-			AuthID: dbChirp.UserID,
-		})
+	cfg.respondWithChirps(w, r, dbChirps)
+}
+
+// respondWithChirps renders the dbChirps visible to the caller, ordered by ID
+// according to the "sort" query parameter.
+func (cfg *apiConfig) respondWithChirps(w http.ResponseWriter, r *http.Request, dbChirps []database.Chirp) {
+	viewerID := requestUserID(r)
+	dbChirps, err := cfg.visibleChirps(viewerID, dbChirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch Chirps")
+		return
+	}
+
+	chirps, err := cfg.newChirps(dbChirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch Chirps")
+		return
+	}
+	err = cfg.applyContentFilters(viewerID, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch Chirps")
+		return
 	}
 
 	order := r.URL.Query().Get("sort")
@@ -203,29 +424,93 @@ func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Reque
 	respondWithJSON(w, http.StatusOK, chirps)
 }
 
+// newChirps converts dbChirps into API responses, filling in their reaction
+// counts and attachments.
+func (cfg *apiConfig) newChirps(dbChirps []database.Chirp) ([]Chirp, error) {
+	reactionCounts, err := cfg.DB.GetReactionCounts()
+	if err != nil {
+		return nil, err
+	}
+	media, err := cfg.DB.GetMediaForChirps(dbChirps)
+	if err != nil {
+		return nil, err
+	}
+
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		counts := reactionCounts[dbChirp.ID]
+		if counts == nil {
+			counts = map[string]int{}
+		}
+		hashtags := dbChirp.Hashtags
+		if hashtags == nil {
+			hashtags = []database.Hashtag{}
+		}
+		mentions := dbChirp.Mentions
+		if mentions == nil {
+			mentions = []database.Mention{}
+		}
+		attachments := []Attachment{}
+		for _, id := range dbChirp.MediaIDs {
+			if m, ok := media[id]; ok {
+				attachments = append(attachments, newAttachment(m))
+			}
+		}
+
+		chirps = append(chirps, Chirp{
+			ID:        dbChirp.ID,
+			Body:      dbChirp.Body,
+			AuthID:    dbChirp.UserID,
+			Reactions: counts,
+			Hashtags:  hashtags,
+			Mentions:  mentions,
+			Media:     attachments,
+		})
+	}
+
+	return chirps, nil
+}
+
+// respondWithChirp renders a single chirp with the given status code.
+func (cfg *apiConfig) respondWithChirp(w http.ResponseWriter, code int, dbChirp database.Chirp) {
+	chirps, err := cfg.newChirps([]database.Chirp{dbChirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch Chirp")
+		return
+	}
+
+	respondWithJSON(w, code, chirps[0])
+}
+
 func (cfg *apiConfig) handlerChirpsRetrieveID(w http.ResponseWriter, r *http.Request) {
 	param := chi.URLParam(r, "chirpsID")
 	v, err := strconv.Atoi(param)
 	if err != nil {
 		log.Fatal("Enter a valid chirp ID")
 	}
-	text, err := cfg.DB.GetChirpByID(v)
+	dbChirp, err := cfg.DB.GetChirp(v)
 	if err != nil {
 		respondWithError(w, 404, "No chirp found")
 		return
 	}
 
-	chirp := Chirp{
-		Body: text,
-		ID:   v,
+	visible, err := cfg.visibleChirps(requestUserID(r), []database.Chirp{dbChirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch Chirp")
+		return
+	}
+	if len(visible) == 0 {
+		respondWithError(w, 404, "No chirp found")
+		return
 	}
 
-	respondWithJSON(w, http.StatusOK, chirp)
+	cfg.respondWithChirp(w, http.StatusOK, dbChirp)
 }
 
 func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Body string `json:"body"`
+		Body     string `json:"body"`
+		MediaIDs []int  `json:"media_ids"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -236,42 +521,49 @@ func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	cleaned, err := validateChirp(params.Body)
+	author := requestPrincipal(r).User
+
+	cleaned, flagged, err := cfg.validateChirp(r.Context(), params.Body, author)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	token, err := getAuthorization(r)
-	id, err := auth.ValidateJWT(token, cfg.SecSig, "chirpy-access")
-	strid, err := strconv.Atoi(id)
+	hashtags, mentions, err := cfg.extractEntities(author.ID, cleaned)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't parse chirp")
+		return
+	}
 
-	chirp, err := cfg.DB.CreateChirp(cleaned, strid)
+	mediaIDs, err := cfg.validateAttachments(params.MediaIDs, author.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, Chirp{
-		ID:     chirp.ID,
-		Body:   chirp.Body,
-		AuthID: strid,
+	chirp, err := cfg.DB.CreateChirp(database.Chirp{
+		Body:     cleaned,
+		UserID:   author.ID,
+		Hashtags: hashtags,
+		Mentions: mentions,
+		MediaIDs: mediaIDs,
+		Flagged:  flagged,
 	})
-}
-
-func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) {
-	token, err := getAuthorization(r)
 	if err != nil {
-		respondWithError(w, 401, "Malformed header")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
 
-	strUserID, err := auth.ValidateJWT(token, cfg.SecSig, "chirpy-access")
+	err = cfg.Timelines.ChirpCreated(chirp)
 	if err != nil {
-		respondWithError(w, 402, "Invalid token")
-		return
+		log.Printf("Couldn't fan out chirp %d: %s", chirp.ID, err)
 	}
-	UserID, err := strconv.Atoi(strUserID)
+
+	cfg.respondWithChirp(w, http.StatusCreated, chirp)
+}
+
+func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) {
+	UserID := requestPrincipal(r).User.ID
 
 	param := chi.URLParam(r, "chirpsID")
 	v, err := strconv.Atoi(param)
@@ -279,7 +571,13 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 		log.Fatal("Enter a valid chirp ID")
 	}
 
-	if UserID != v {
+	chirp, err := cfg.DB.GetChirp(v)
+	if err != nil {
+		respondWithError(w, 404, "No chirp found")
+		return
+	}
+
+	if UserID != chirp.UserID {
 		respondWithError(w, 403, "Unauthorized action")
 		return
 	}
@@ -290,34 +588,56 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	err = cfg.Timelines.ChirpDeleted(chirp)
+	if err != nil {
+		log.Printf("Couldn't remove chirp %d from timelines: %s", chirp.ID, err)
+	}
+
 	w.WriteHeader(200)
 }
 
-func validateChirp(body string) (string, error) {
+// validateChirp runs body through the moderation rules and then the plugin
+// chain, returning the body to store and the names of any rules that flagged
+// it for review.
+func (cfg *apiConfig) validateChirp(ctx context.Context, body string, author database.User) (string, []string, error) {
 	const maxChirpLength = 140
 	if len(body) > maxChirpLength {
-		return "", errors.New("Chirp is too long")
+		return "", nil, errors.New("Chirp is too long")
 	}
 
-	badWords := map[string]struct{}{
-		"kerfuffle": {},
-		"sharbert":  {},
-		"fornax":    {},
+	result := cfg.Moderation.Pipeline().Moderate(body)
+	if result.Rejected {
+		return "", nil, errors.New(result.Reason)
 	}
-	cleaned := getCleanedBody(body, badWords)
-	return cleaned, nil
-}
 
-func getCleanedBody(body string, badWords map[string]struct{}) string {
-	words := strings.Split(body, " ")
-	for i, word := range words {
-		loweredWord := strings.ToLower(word)
-		if _, ok := badWords[loweredWord]; ok {
-			words[i] = "****"
+	out := cfg.Plugins.Run(ctx, plugins.Input{
+		Body: result.Body,
+		Author: plugins.Author{
+			ID:          author.ID,
+			Email:       author.EmailID,
+			IsChirpyRed: author.Subscription,
+		},
+	})
+	switch out.Action {
+	case plugins.ActionReject:
+		return "", nil, errors.New(out.Reason)
+	case plugins.ActionMask:
+		// A plugin's replacement body gets the same checks as the original.
+		if len(out.Body) > maxChirpLength {
+			return "", nil, errors.New("Chirp is too long after moderation")
+		}
+		masked := cfg.Moderation.Pipeline().Moderate(out.Body)
+		if masked.Rejected {
+			return "", nil, errors.New(masked.Reason)
+		}
+		for _, name := range masked.Flagged {
+			if !slices.Contains(result.Flagged, name) {
+				result.Flagged = append(result.Flagged, name)
+			}
 		}
+		return masked.Body, result.Flagged, nil
 	}
-	cleaned := strings.Join(words, " ")
-	return cleaned
+	return out.Body, result.Flagged, nil
 }
 
 func (cfg *apiConfig) handlerUserCreate(w http.ResponseWriter, r *http.Request) {
@@ -359,19 +679,11 @@ func (cfg *apiConfig) handlerUserUpdate(w http.ResponseWriter, r *http.Request)
 		Pass  string `json:"password"`
 	}
 
-	token, err := getAuthorization(r)
-	if err != nil {
-		respondWithError(w, 401, err.Error())
-		return
-	}
-	subject, err := auth.ValidateJWT(token, cfg.SecSig, "chirpy-access")
-	if err != nil {
-		respondWithError(w, 401, "Error: Malformed Authorization header")
-	}
+	old := requestPrincipal(r).User
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
@@ -383,17 +695,18 @@ func (cfg *apiConfig) handlerUserUpdate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	userIDInt, err := strconv.Atoi(subject)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
-		return
-	}
-
-	user, err := cfg.DB.UpdateUser(userIDInt, params.Email, hashedPassword)
+	user, err := cfg.DB.UpdateUser(old.ID, params.Email, hashedPassword)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user")
 		return
 	}
+	cfg.recordAudit(r, user.ID, "user.password_change", userTarget(user.ID), nil)
+	if old.EmailID != user.EmailID {
+		cfg.recordAudit(r, user.ID, "user.email_change", userTarget(user.ID), map[string]string{
+			"from": old.EmailID,
+			"to":   user.EmailID,
+		})
+	}
 
 	respondWithJSON(w, 200, User{
 		ID:      user.ID,
@@ -408,12 +721,6 @@ func (cfg *apiConfig) handlerUserValidate(w http.ResponseWriter, r *http.Request
 		ExpiryTime int    `json:"expires_in_seconds"`
 	}
 
-	type response struct {
-		User
-		Token  string `json:"token"`
-		Token2 string `json:"refresh_token"`
-	}
-
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
 	err := decoder.Decode(&params)
@@ -424,61 +731,125 @@ func (cfg *apiConfig) handlerUserValidate(w http.ResponseWriter, r *http.Request
 
 	pass, err := cfg.DB.GetUser(params.Email)
 	if err != nil {
+		cfg.recordAudit(r, 0, "auth.login_failed", "", map[string]string{
+			"email":  params.Email,
+			"reason": "unknown_user",
+		})
 		respondWithError(w, http.StatusInternalServerError, "Invalid user")
 		return
 	}
 
 	if bcrypt.CompareHashAndPassword(pass.Password, []byte(params.Pass)) != nil {
+		cfg.recordAudit(r, 0, "auth.login_failed", userTarget(pass.ID), map[string]string{
+			"email":  params.Email,
+			"reason": "bad_password",
+		})
 		respondWithError(w, 401, "Incorrect password")
 		return
 	}
+	if pass.Suspension.Active(time.Now().UTC()) {
+		cfg.recordAudit(r, pass.ID, "auth.login_failed", userTarget(pass.ID), map[string]string{
+			"reason": "suspended",
+		})
+		respondWithSuspension(w, pass.Suspension)
+		return
+	}
+	cfg.recordAudit(r, pass.ID, "auth.login", userTarget(pass.ID), nil)
 
-	access_time := 60 * 60 * time.Second
-	ref_time := 60 * 24 * access_time
+	cfg.respondWithLogin(w, r, pass, cfg.Tokens.accessLifetime(params.ExpiryTime))
+}
+
+// respondWithLogin starts a session for user and writes its tokens.
+func (cfg *apiConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, user database.User, expiresIn time.Duration) {
+	type response struct {
+		User
+		PasswordResetRequired bool   `json:"password_reset_required,omitempty"`
+		Token                 string `json:"token"`
+		Token2                string `json:"refresh_token"`
+	}
 
-	token_access, err := auth.MakeJWT(pass.ID, cfg.SecSig, "chirpy-access", time.Duration(access_time))
-	token_ref, err := auth.MakeJWT(pass.ID, cfg.SecSig, "chirpy-refresh", time.Duration(ref_time))
+	token_ref, session, err := cfg.newRefreshFamily(r, user.ID, "", nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token")
+		return
+	}
+	token_access, err := cfg.makeAccessToken(user, session, expiresIn)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access token")
+		return
+	}
 
 	respondWithJSON(w, http.StatusOK, response{
 		User: User{
-			ID:           pass.ID,
-			EmailID:      pass.EmailID,
-			Subscription: pass.Subscription,
+			ID:           user.ID,
+			EmailID:      user.EmailID,
+			Subscription: user.Subscription,
+			Role:         user.EffectiveRole(),
 		},
-		Token:  token_access,
-		Token2: token_ref,
+		PasswordResetRequired: user.PasswordResetRequired,
+		Token:                 token_access,
+		Token2:                token_ref,
 	})
 }
 
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	token, err := getAuthorization(r)
 	if err != nil {
 		respondWithError(w, 401, err.Error())
+		return
 	}
 
-	subID, err := auth.ValidateJWT(token, cfg.SecSig, "chirpy-refresh")
-	if err != nil {
+	record, err := cfg.DB.GetRefreshToken(auth.HashToken(token))
+	// OAuth clients refresh through the token endpoint, keeping their scopes.
+	if err != nil || record.ClientID != "" {
 		respondWithError(w, 401, "Invalid token")
 		return
 	}
 
-	val, err := cfg.DB.IsTokenRevoked(token)
-	if val {
-		respondWithError(w, 401, "Resfresh token revoked already")
+	isubID := record.UserID
+	// Read the role afresh so promotions and demotions apply on refresh.
+	user, err := cfg.DB.GetUserID(isubID)
+	if err != nil {
+		respondWithError(w, 401, "Invalid token")
+		return
+	}
+	if user.Suspension.Active(time.Now().UTC()) {
+		respondWithSuspension(w, user.Suspension)
 		return
 	}
 
-	access_time := 60 * 60 * time.Second
-	isubID, err := strconv.Atoi(subID)
+	token_ref, err := cfg.rotateRefreshToken(r, token)
+	if errors.Is(err, database.ErrTokenReused) {
+		respondWithError(w, 401, "Refresh token reused, please log in again")
+		return
+	}
+	if errors.Is(err, database.ErrTokenExpired) {
+		respondWithError(w, 401, "Refresh token expired")
+		return
+	}
+	if errors.Is(err, database.ErrTokenRevoked) || errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, 401, "Refresh token revoked already")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token")
+		return
+	}
 
-	token_access, err := auth.MakeJWT(isubID, cfg.SecSig, "chirpy-access", time.Duration(access_time))
+	token_access, err := cfg.makeAccessToken(user, record.FamilyID, cfg.Tokens.AccessLifetime)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access token")
+		return
+	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: token_access,
+		Token:        token_access,
+		RefreshToken: token_ref,
 	})
 }
 
@@ -519,13 +890,15 @@ func (cfg *apiConfig) handlerPolkaWebhook(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	resUser.Subscription = true
-
-	err = cfg.DB.GenUpdateUser(resUser, params.Data.UserID)
+	_, err = cfg.DB.SetSubscription(params.Data.UserID, true)
 	if err != nil {
 		respondWithError(w, 404, "Couldn't process subscription")
 		return
 	}
+	cfg.recordAudit(r, 0, "user.subscription_change", userTarget(resUser.ID), map[string]string{
+		"is_chirpy_red": "true",
+		"source":        "polka",
+	})
 
 	w.WriteHeader(200)
 	return
@@ -535,16 +908,18 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	token, err := getAuthorization(r)
 	if err != nil {
 		respondWithError(w, 401, err.Error())
+		return
 	}
-	_, err = auth.ValidateJWT(token, cfg.SecSig, "chirpy-refresh")
-	if err != nil {
+	record, err := cfg.DB.RevokeRefreshToken(auth.HashToken(token), "revoked")
+	if errors.Is(err, database.ErrNotExist) {
 		respondWithError(w, 401, "Invalid token")
 		return
 	}
-	err = cfg.DB.RevokeToken(token)
 	if err != nil {
 		respondWithError(w, 401, "Unable to revoke token")
+		return
 	}
+	cfg.recordAudit(r, record.UserID, "auth.token_revoke", userTarget(record.UserID), nil)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
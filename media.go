@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"internal/blobstore"
+	"internal/database"
+	"internal/media"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxMediaBytes          = 5 << 20
+	maxAttachmentsPerChirp = 4
+	mediaPresignExpiry     = 15 * time.Minute
+)
+
+type Attachment struct {
+	ID           int    `json:"id"`
+	ContentType  string `json:"content_type"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+func newAttachment(m database.Media) Attachment {
+	return Attachment{
+		ID:           m.ID,
+		ContentType:  m.ContentType,
+		Width:        m.Width,
+		Height:       m.Height,
+		URL:          "/media/" + m.BlobKey,
+		ThumbnailURL: "/media/" + m.ThumbnailKey,
+	}
+}
+
+func (cfg *apiConfig) handlerMediaUpload(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).User.ID
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxMediaBytes+1<<10)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large")
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Couldn't read file")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxMediaBytes+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read file")
+		return
+	}
+	if len(data) > maxMediaBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large")
+		return
+	}
+
+	img, err := media.Process(data)
+	if errors.Is(err, media.ErrUnsupportedType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Only JPEG, PNG and GIF images are supported")
+		return
+	}
+	if errors.Is(err, media.ErrTooLarge) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Image dimensions are too large")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't process image")
+		return
+	}
+
+	blobKey, err := cfg.Media.Put(r.Context(), img.Data, img.ContentType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store image")
+		return
+	}
+	thumbnailKey, err := cfg.Media.Put(r.Context(), img.Thumbnail, img.ThumbnailContentType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store image")
+		return
+	}
+
+	m, err := cfg.DB.CreateMedia(database.Media{
+		OwnerID:      userID,
+		ContentType:  img.ContentType,
+		Size:         len(img.Data),
+		Width:        img.Width,
+		Height:       img.Height,
+		BlobKey:      blobKey,
+		ThumbnailKey: thumbnailKey,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save media")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, newAttachment(m))
+}
+
+func (cfg *apiConfig) handlerMediaServe(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	// Stores that can hand out direct links serve the bytes themselves.
+	url, err := cfg.Media.PresignGet(r.Context(), key, mediaPresignExpiry)
+	if err == nil {
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+	if !errors.Is(err, blobstore.ErrPresignUnsupported) {
+		respondWithError(w, http.StatusNotFound, "Media not found")
+		return
+	}
+
+	rc, err := cfg.Media.Open(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Media not found")
+		return
+	}
+	defer rc.Close()
+
+	// Blobs are content-addressed, so a key always refers to the same bytes.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", time.Time{}, rs)
+		return
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(rc, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read media")
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(head[:n]))
+	w.WriteHeader(http.StatusOK)
+	w.Write(head[:n])
+	io.Copy(w, rc)
+}
+
+// validateAttachments checks that every ID in mediaIDs was uploaded by
+// userID, dropping duplicates while keeping the caller's order.
+func (cfg *apiConfig) validateAttachments(mediaIDs []int, userID int) ([]int, error) {
+	seen := map[int]struct{}{}
+	valid := []int{}
+	for _, id := range mediaIDs {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+
+		m, err := cfg.DB.GetMedia(id)
+		if err != nil || m.OwnerID != userID {
+			return nil, fmt.Errorf("Media %d not found", id)
+		}
+		valid = append(valid, id)
+	}
+	if len(valid) > maxAttachmentsPerChirp {
+		return nil, fmt.Errorf("Chirps can have at most %d attachments", maxAttachmentsPerChirp)
+	}
+	return valid, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"internal/database"
+	"internal/moderation"
+	"internal/plugins"
+)
+
+func TestValidateChirpRechecksPluginMask(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "moderation.json")
+	err := os.WriteFile(rules, []byte(`{"rules": [
+		{"name": "no masked", "action": "reject", "words": ["masked"], "reason": "Chirp mentions masking"}
+	]}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moderator, err := moderation.NewReloader(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fixture masks chirps starting with m as "masked" and chirps
+	// starting with l as 150 x's.
+	chain, err := plugins.LoadDir(context.Background(), filepath.Join("internal", "plugins", "testdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close(context.Background())
+	cfg := &apiConfig{Moderation: moderator, Plugins: chain}
+
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr string
+	}{
+		{name: "allowed", body: "a fine chirp", want: "a fine chirp"},
+		{name: "mask too long", body: "long", wantErr: "Chirp is too long after moderation"},
+		{name: "mask rejected by the rules", body: "mask me", wantErr: "Chirp mentions masking"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := cfg.validateChirp(context.Background(), tt.body, database.User{})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"internal/auth"
+	"internal/database"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	oauthCodeLifetime        = 2 * time.Minute
+	maxOAuthClientNameLength = 100
+	maxOAuthRedirectURIs     = 10
+)
+
+var scopeDescriptions = map[string]string{
+	scopeChirpsRead:  "Read chirps, including ones only visible to you",
+	scopeChirpsWrite: "Post, delete, react to and report chirps as you",
+	scopeUsersRead:   "See who you follow, block and mute, and your filters",
+	scopeUsersWrite:  "Follow, block and mute people and change your filters",
+}
+
+// OAuthClient is a registered app as shown to its owner. Secret is only
+// filled in when a confidential client is registered.
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+	Secret       string    `json:"client_secret,omitempty"`
+}
+
+func newOAuthClient(client database.OAuthClient) OAuthClient {
+	return OAuthClient{
+		ID:           client.ID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+		Confidential: client.Confidential(),
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// validRedirectURI accepts https URLs, http URLs on the loopback interface
+// and private-use schemes for native apps, none with a fragment.
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Fragment != "" || u.Scheme == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		// Private-use schemes are reverse domain names, like com.example.app.
+		return strings.Contains(u.Scheme, ".")
+	}
+}
+
+func (cfg *apiConfig) handlerOAuthClientsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		// Confidential clients can keep a secret, like server-side apps.
+		Confidential bool `json:"confidential"`
+	}
+
+	ownerID := requestPrincipal(r).User.ID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+	if params.Name == "" || len(params.Name) > maxOAuthClientNameLength {
+		respondWithError(w, http.StatusBadRequest, "Invalid name")
+		return
+	}
+	if len(params.RedirectURIs) == 0 || len(params.RedirectURIs) > maxOAuthRedirectURIs {
+		respondWithError(w, http.StatusBadRequest, "Invalid redirect URIs")
+		return
+	}
+	for _, uri := range params.RedirectURIs {
+		if !validRedirectURI(uri) {
+			respondWithError(w, http.StatusBadRequest, "Invalid redirect URI: "+uri)
+			return
+		}
+	}
+
+	clientID, err := auth.NewTokenID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't register client")
+		return
+	}
+	client := database.OAuthClient{
+		ID:           clientID,
+		Name:         params.Name,
+		OwnerID:      ownerID,
+		RedirectURIs: params.RedirectURIs,
+	}
+	secret := ""
+	if params.Confidential {
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't register client")
+			return
+		}
+		client.SecretHash = auth.HashToken(secret)
+	}
+	client, err = cfg.DB.CreateOAuthClient(client)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't register client")
+		return
+	}
+	cfg.recordAudit(r, ownerID, "oauth.client_create", oauthClientTarget(client.ID), map[string]string{
+		"name": client.Name,
+	})
+
+	response := newOAuthClient(client)
+	response.Secret = secret
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) handlerOAuthClientsRetrieve(w http.ResponseWriter, r *http.Request) {
+	ownerID := requestPrincipal(r).User.ID
+
+	dbClients, err := cfg.DB.GetOAuthClients(ownerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch clients")
+		return
+	}
+
+	clients := make([]OAuthClient, 0, len(dbClients))
+	for _, client := range dbClients {
+		clients = append(clients, newOAuthClient(client))
+	}
+	respondWithJSON(w, http.StatusOK, clients)
+}
+
+// handlerOAuthClientDelete removes a client and revokes every token issued
+// to it.
+func (cfg *apiConfig) handlerOAuthClientDelete(w http.ResponseWriter, r *http.Request) {
+	ownerID := requestPrincipal(r).User.ID
+	clientID := chi.URLParam(r, "clientID")
+
+	err := cfg.DB.DeleteOAuthClient(ownerID, clientID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Client not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete client")
+		return
+	}
+	cfg.recordAudit(r, ownerID, "oauth.client_delete", oauthClientTarget(clientID), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func oauthClientTarget(clientID string) string {
+	return "oauth_client:" + clientID
+}
+
+// authorizeRequest is a validated authorization request.
+type authorizeRequest struct {
+	Client        database.OAuthClient
+	RedirectURI   string
+	State         string
+	CodeChallenge string
+	Scopes        []string
+}
+
+// oauthError is an OAuth error code and description (RFC 6749 section
+// 4.1.2.1 and 5.2).
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// parseAuthorizeRequest validates an authorization request. Until the client
+// and redirect URI check out, errors can't be sent back to the client, so
+// the returned request has an empty RedirectURI and the caller shows them to
+// the user instead.
+func (cfg *apiConfig) parseAuthorizeRequest(values url.Values) (authorizeRequest, *oauthError) {
+	req := authorizeRequest{State: values.Get("state")}
+
+	client, err := cfg.DB.GetOAuthClient(values.Get("client_id"))
+	if err != nil {
+		return req, &oauthError{"invalid_client", "Unknown client"}
+	}
+	req.Client = client
+
+	redirectURI := values.Get("redirect_uri")
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	for _, registered := range client.RedirectURIs {
+		if redirectURI == registered {
+			req.RedirectURI = redirectURI
+		}
+	}
+	if req.RedirectURI == "" {
+		return req, &oauthError{"invalid_request", "Redirect URI isn't registered for this client"}
+	}
+
+	if values.Get("response_type") != "code" {
+		return req, &oauthError{"unsupported_response_type", "Only the code response type is supported"}
+	}
+	req.CodeChallenge = values.Get("code_challenge")
+	if req.CodeChallenge == "" || values.Get("code_challenge_method") != "S256" {
+		return req, &oauthError{"invalid_request", "PKCE with the S256 method is required"}
+	}
+	scopes, ok := normalizeScopes(strings.Fields(values.Get("scope")))
+	if !ok {
+		return req, &oauthError{"invalid_scope", "Unknown or missing scope"}
+	}
+	req.Scopes = scopes
+
+	return req, nil
+}
+
+// redirectWithResult sends the user agent back to the client with params
+// and the request's state added to the redirect URI's query.
+func redirectWithResult(w http.ResponseWriter, r *http.Request, req authorizeRequest, params url.Values) {
+	u, _ := url.Parse(req.RedirectURI)
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	u.RawQuery = query.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func redirectWithOAuthError(w http.ResponseWriter, r *http.Request, req authorizeRequest, oauthErr *oauthError) {
+	redirectWithResult(w, r, req, url.Values{
+		"error":             {oauthErr.Code},
+		"error_description": {oauthErr.Description},
+	})
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+
+<head>
+	<title>Authorize {{.Client.Name}}</title>
+</head>
+
+<body>
+	{{if .Error}}
+	<h1>Can't authorize this app</h1>
+	<p role="alert">{{.Error}}</p>
+	{{else}}
+	<h1>{{.Client.Name}} wants to use your Chirpy account</h1>
+	<p>If you allow it, it will be able to:</p>
+	<ul>
+		{{range .Scopes}}<li>{{.}}</li>{{end}}
+	</ul>
+	{{if .LoginError}}<p role="alert">{{.LoginError}}</p>{{end}}
+	<form method="post" action="/oauth/authorize">
+		<input type="hidden" name="response_type" value="code">
+		<input type="hidden" name="client_id" value="{{.Client.ID}}">
+		<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+		<input type="hidden" name="scope" value="{{.Scope}}">
+		<input type="hidden" name="state" value="{{.State}}">
+		<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+		<input type="hidden" name="code_challenge_method" value="S256">
+		<p><label>Email <input type="email" name="email" value="{{.Email}}" required></label></p>
+		<p><label>Password <input type="password" name="password" required></label></p>
+		<button type="submit" name="decision" value="approve">Allow</button>
+		<button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+	</form>
+	<p>You'll be sent back to {{.RedirectHost}}.</p>
+	{{end}}
+</body>
+
+</html>
+`))
+
+// renderConsent writes the consent page for req, or an error page if
+// errMsg is set.
+func renderConsent(w http.ResponseWriter, code int, req authorizeRequest, errMsg, loginErr, email string) {
+	type page struct {
+		authorizeRequest
+		Scopes       []string
+		Scope        string
+		RedirectHost string
+		Error        string
+		LoginError   string
+		Email        string
+	}
+
+	descriptions := []string{}
+	for _, scope := range req.Scopes {
+		descriptions = append(descriptions, scopeDescriptions[scope])
+	}
+	redirectHost := req.RedirectURI
+	if u, err := url.Parse(req.RedirectURI); err == nil && u.Host != "" {
+		redirectHost = u.Host
+	}
+
+	// The consent page takes a password, so it must not be framed.
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	consentTemplate.Execute(w, page{
+		authorizeRequest: req,
+		Scopes:           descriptions,
+		Scope:            strings.Join(req.Scopes, " "),
+		RedirectHost:     redirectHost,
+		Error:            errMsg,
+		LoginError:       loginErr,
+		Email:            email,
+	})
+}
+
+// handlerOAuthAuthorize shows the consent page for an authorization request.
+func (cfg *apiConfig) handlerOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	req, oauthErr := cfg.parseAuthorizeRequest(r.URL.Query())
+	if oauthErr != nil {
+		if req.RedirectURI == "" {
+			renderConsent(w, http.StatusBadRequest, req, oauthErr.Description, "", "")
+			return
+		}
+		redirectWithOAuthError(w, r, req, oauthErr)
+		return
+	}
+
+	renderConsent(w, http.StatusOK, req, "", "", "")
+}
+
+// handlerOAuthConsent handles the consent form. Chirpy has no browser
+// sessions, so the user signs in on the consent page itself; their password
+// never reaches the client.
+func (cfg *apiConfig) handlerOAuthConsent(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		renderConsent(w, http.StatusBadRequest, authorizeRequest{}, "Malformed request", "", "")
+		return
+	}
+	req, oauthErr := cfg.parseAuthorizeRequest(r.PostForm)
+	if oauthErr != nil {
+		if req.RedirectURI == "" {
+			renderConsent(w, http.StatusBadRequest, req, oauthErr.Description, "", "")
+			return
+		}
+		redirectWithOAuthError(w, r, req, oauthErr)
+		return
+	}
+
+	if r.PostForm.Get("decision") != "approve" {
+		redirectWithOAuthError(w, r, req, &oauthError{"access_denied", "The user denied the request"})
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	user, err := cfg.DB.GetUser(email)
+	if err != nil || bcrypt.CompareHashAndPassword(user.Password, []byte(r.PostForm.Get("password"))) != nil {
+		cfg.recordAudit(r, 0, "auth.login_failed", "", map[string]string{
+			"email":  email,
+			"reason": "bad_credentials",
+			"source": "oauth",
+		})
+		renderConsent(w, http.StatusUnauthorized, req, "", "Incorrect email or password", email)
+		return
+	}
+	if user.Suspension.Active(time.Now().UTC()) {
+		cfg.recordAudit(r, user.ID, "auth.login_failed", userTarget(user.ID), map[string]string{
+			"reason": "suspended",
+			"source": "oauth",
+		})
+		renderConsent(w, http.StatusForbidden, req, "", "Your account is suspended", email)
+		return
+	}
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		redirectWithOAuthError(w, r, req, &oauthError{"server_error", "Couldn't issue code"})
+		return
+	}
+	err = cfg.DB.CreateOAuthCode(database.OAuthCode{
+		Hash:          auth.HashToken(code),
+		ClientID:      req.Client.ID,
+		UserID:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        req.Scopes,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().UTC().Add(oauthCodeLifetime),
+	})
+	if err != nil {
+		redirectWithOAuthError(w, r, req, &oauthError{"server_error", "Couldn't issue code"})
+		return
+	}
+	cfg.recordAudit(r, user.ID, "oauth.authorize", oauthClientTarget(req.Client.ID), map[string]string{
+		"scope": strings.Join(req.Scopes, " "),
+	})
+
+	redirectWithResult(w, r, req, url.Values{"code": {code}})
+}
+
+// respondWithOAuthError writes a token endpoint error (RFC 6749 section 5.2).
+func respondWithOAuthError(w http.ResponseWriter, code int, oauthErr oauthError) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, oauthErr)
+}
+
+// authenticateClient identifies the client calling the token endpoint from
+// HTTP Basic credentials or the form, checking the secret of confidential
+// clients.
+func (cfg *apiConfig) authenticateClient(r *http.Request) (database.OAuthClient, bool) {
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := cfg.DB.GetOAuthClient(clientID)
+	if err != nil {
+		return database.OAuthClient{}, false
+	}
+	if client.Confidential() {
+		hash := auth.HashToken(secret)
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(client.SecretHash)) != 1 {
+			return database.OAuthClient{}, false
+		}
+	}
+	return client, true
+}
+
+// handlerOAuthToken exchanges authorization codes and refresh tokens for
+// access tokens.
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthError{"invalid_request", "Malformed request"})
+		return
+	}
+	client, ok := cfg.authenticateClient(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		respondWithOAuthError(w, http.StatusUnauthorized, oauthError{"invalid_client", "Client authentication failed"})
+		return
+	}
+
+	var (
+		user         database.User
+		familyID     string
+		refreshToken string
+		scopes       []string
+	)
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, err := cfg.DB.RedeemOAuthCode(auth.HashToken(r.PostForm.Get("code")))
+		if errors.Is(err, database.ErrTokenReused) {
+			cfg.recordAudit(r, code.UserID, "oauth.code_reuse", oauthClientTarget(code.ClientID), nil)
+		}
+		if err != nil {
+			respondWithOAuthError(w, http.StatusBadRequest, oauthError{"invalid_grant", "Invalid or expired code"})
+			return
+		}
+		if code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+			respondWithOAuthError(w, http.StatusBadRequest, oauthError{"invalid_grant", "Code was issued to another client or redirect URI"})
+			return
+		}
+		if !auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+			respondWithOAuthError(w, http.StatusBadRequest, oauthError{"invalid_grant", "Code verifier doesn't match"})
+			return
+		}
+		user, err = cfg.DB.GetUserID(code.UserID)
+		if err != nil || user.Suspension.Active(time.Now().UTC()) {
+			respondWithOAuthError(w, http.StatusBadRequest, oauthError{"invalid_grant", "User can't be authorized"})
+			return
+		}
+		scopes = code.Scopes
+		refreshToken, familyID, err = cfg.newRefreshFamily(r, user.ID, client.ID, scopes)
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, oauthError{"server_error", "Couldn't issue tokens"})
+			return
+		}
+
+	case "refresh_token":
+		token := r.PostForm.Get("refresh_token")
+		record, err := cfg.DB.GetRefreshToken(auth.HashToken(token))
+		if err != nil || record.ClientID != client.ID {
+			respondWithOAuthError(w, http.StatusBadRequest, oauthError{"invalid_grant", "Invalid refresh token"})
+			return
+		}
+		user, err = cfg.DB.GetUserID(record.UserID)
+		if err != nil || user.Suspension.Active(time.Now().UTC()) {
+			respondWithOAuthError(w, http.StatusBadRequest, oauthError{"invalid_grant", "User can't be authorized"})
+			return
+		}
+		refreshToken, err = cfg.rotateRefreshToken(r, token)
+		if err != nil {
+			respondWithOAuthError(w, http.StatusBadRequest, oauthError{"invalid_grant", "Refresh token is expired, revoked or reused"})
+			return
+		}
+		familyID = record.FamilyID
+		scopes = record.Scopes
+
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, oauthError{"unsupported_grant_type", "Use authorization_code or refresh_token"})
+		return
+	}
+
+	claims := accessClaims(user, familyID)
+	// Staff permissions never pass to apps.
+	claims.Role = ""
+	claims.ClientID = client.ID
+	claims.Scope = strings.Join(scopes, " ")
+	accessToken, err := auth.MakeJWT(user.ID, claims, cfg.Keys, cfg.Tokens.Access, cfg.Tokens.AccessLifetime)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthError{"server_error", "Couldn't issue tokens"})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, response{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(cfg.Tokens.AccessLifetime / time.Second),
+		RefreshToken: refreshToken,
+		Scope:        claims.Scope,
+	})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"internal/auth"
+	"internal/database"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	oidcLoginLifetime = 10 * time.Minute
+	oidcStateCookie   = "chirpy_oidc_state"
+)
+
+var errUnverifiedEmail = errors.New("provider didn't return a verified email")
+
+// loadOIDCProvider configures sign-in with an external OpenID Connect
+// provider from OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET and
+// OIDC_REDIRECT_URL. It returns nil if OIDC_ISSUER isn't set.
+func loadOIDCProvider() (*auth.OIDCProvider, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+	return auth.NewOIDCProvider(issuer, os.Getenv("OIDC_CLIENT_ID"), os.Getenv("OIDC_CLIENT_SECRET"), os.Getenv("OIDC_REDIRECT_URL"))
+}
+
+// handlerOIDCLogin starts a sign-in with the external provider, sending the
+// user there with a fresh state, nonce and PKCE challenge. The state is also
+// set in a cookie so the callback only completes in the browser that started
+// the sign-in.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if cfg.OIDC == nil {
+		respondWithError(w, http.StatusNotFound, "OIDC login isn't configured")
+		return
+	}
+
+	secrets := make([]string, 3)
+	for i := range secrets {
+		secret, err := auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't start login")
+			return
+		}
+		secrets[i] = secret
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	authURL, err := cfg.OIDC.AuthCodeURL(r.Context(), state, nonce, auth.PKCEChallenge(verifier))
+	if err != nil {
+		log.Printf("Couldn't start OIDC login: %s", err)
+		respondWithError(w, http.StatusBadGateway, "Couldn't reach identity provider")
+		return
+	}
+	expiresAt := time.Now().UTC().Add(oidcLoginLifetime)
+	err = cfg.DB.CreateOIDCLogin(database.OIDCLogin{
+		StateHash:    auth.HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/login/oidc",
+		Expires:  expiresAt,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		// Lax, not Strict, so the cookie comes back on the provider's
+		// top-level redirect.
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handlerOIDCCallback finishes a sign-in when the provider redirects back,
+// redeeming the code for a verified ID token and logging in the user it
+// names.
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if cfg.OIDC == nil {
+		respondWithError(w, http.StatusNotFound, "OIDC login isn't configured")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		respondWithError(w, http.StatusBadRequest, "Login state doesn't match, please try again")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/login/oidc",
+		MaxAge:   -1,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+	})
+	login, err := cfg.DB.TakeOIDCLogin(auth.HashToken(state))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Login expired, please try again")
+		return
+	}
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		cfg.recordAudit(r, 0, "auth.login_failed", "", map[string]string{
+			"reason": "provider_" + providerErr,
+			"source": "oidc",
+		})
+		respondWithError(w, http.StatusUnauthorized, "Identity provider refused the login")
+		return
+	}
+
+	claims, err := cfg.OIDC.Exchange(r.Context(), query.Get("code"), login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("Couldn't complete OIDC login: %s", err)
+		cfg.recordAudit(r, 0, "auth.login_failed", "", map[string]string{
+			"reason": "invalid_id_token",
+			"source": "oidc",
+		})
+		respondWithError(w, http.StatusUnauthorized, "Couldn't verify login with identity provider")
+		return
+	}
+
+	user, err := cfg.oidcUser(r, claims)
+	if errors.Is(err, errUnverifiedEmail) {
+		respondWithError(w, http.StatusForbidden, "Identity provider didn't return a verified email")
+		return
+	}
+	if errors.Is(err, database.ErrAmbiguousEmail) {
+		respondWithError(w, http.StatusConflict, "More than one account uses this email")
+		return
+	}
+	if errors.Is(err, database.ErrIdentityConflict) {
+		respondWithError(w, http.StatusConflict, "This account is linked to another identity at the provider")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in")
+		return
+	}
+	if user.Suspension.Active(time.Now().UTC()) {
+		cfg.recordAudit(r, user.ID, "auth.login_failed", userTarget(user.ID), map[string]string{
+			"reason": "suspended",
+			"source": "oidc",
+		})
+		respondWithSuspension(w, user.Suspension)
+		return
+	}
+	cfg.recordAudit(r, user.ID, "auth.login", userTarget(user.ID), map[string]string{
+		"source": "oidc",
+	})
+
+	cfg.respondWithLogin(w, r, user, cfg.Tokens.AccessLifetime)
+}
+
+// oidcUser returns the user linked to the provider account in claims. An
+// account seen for the first time is linked to the user with its email,
+// compared ignoring case, or to a new user if there is none, but only if the
+// provider verified the email. Users created this way get a random password,
+// so they sign in through the provider until an admin resets it.
+func (cfg *apiConfig) oidcUser(r *http.Request, claims auth.IDClaims) (database.User, error) {
+	identity, err := cfg.DB.GetIdentity(claims.Issuer, claims.Subject)
+	if err == nil {
+		return cfg.DB.GetUserID(identity.UserID)
+	}
+	if !errors.Is(err, database.ErrNotExist) {
+		return database.User{}, err
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return database.User{}, errUnverifiedEmail
+	}
+
+	created := false
+	user, err := cfg.DB.GetUserByEmailFold(claims.Email)
+	if errors.Is(err, database.ErrNotExist) {
+		password, err := auth.MakeRefreshToken()
+		if err != nil {
+			return database.User{}, err
+		}
+		hashPass, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+		if err != nil {
+			return database.User{}, err
+		}
+		user, err = cfg.DB.CreateUser(claims.Email, hashPass)
+		if err != nil {
+			return database.User{}, err
+		}
+		created = true
+	} else if err != nil {
+		return database.User{}, err
+	}
+
+	_, err = cfg.DB.LinkIdentity(database.Identity{
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		UserID:  user.ID,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	cfg.recordAudit(r, user.ID, "auth.identity_link", userTarget(user.ID), map[string]string{
+		"issuer":  claims.Issuer,
+		"subject": claims.Subject,
+		"created": fmt.Sprint(created),
+	})
+
+	return user, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"internal/auth"
+	"internal/database"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	scopeChirpsRead  = "chirps:read"
+	scopeChirpsWrite = "chirps:write"
+	scopeUsersRead   = "users:read"
+	scopeUsersWrite  = "users:write"
+
+	maxPersonalTokens          = 50
+	maxPersonalTokenNameLength = 100
+)
+
+// tokenScopes are the scopes personal access tokens and OAuth clients can be
+// granted.
+var tokenScopes = map[string]struct{}{
+	scopeChirpsRead:  {},
+	scopeChirpsWrite: {},
+	scopeUsersRead:   {},
+	scopeUsersWrite:  {},
+}
+
+// PersonalToken is a personal access token as shown to its owner. Token is
+// only filled in when the token is created.
+type PersonalToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Token      string     `json:"token,omitempty"`
+}
+
+func newPersonalToken(token database.PersonalToken) PersonalToken {
+	return PersonalToken{
+		ID:         token.ID,
+		Name:       token.Name,
+		Hint:       token.Hint,
+		Scopes:     token.Scopes,
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+	}
+}
+
+// validatePersonalToken looks up a personal access token and loads its
+// owner, rejecting it like validateAccessToken if they are suspended.
+func (cfg *apiConfig) validatePersonalToken(token string) (Principal, error) {
+	pat, err := cfg.DB.UsePersonalToken(auth.HashToken(token))
+	if err != nil {
+		return Principal{}, err
+	}
+	user, err := cfg.DB.GetUserID(pat.UserID)
+	if err != nil {
+		return Principal{}, err
+	}
+	principal := Principal{User: user, PersonalToken: &pat, Scopes: pat.Scopes}
+	if user.Suspension.Active(time.Now().UTC()) {
+		return principal, errSuspended
+	}
+	return principal, nil
+}
+
+// middlewareRequireScope refuses requests made with a personal access token
+// or OAuth access token that lacks scope. Anonymous requests and session JWTs
+// pass through, so it works on routes with optional auth too.
+func middlewareRequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if principal, ok := principalFromContext(r.Context()); ok && !principal.HasScope(scope) {
+				respondWithError(w, http.StatusForbidden, "Token is missing the "+scope+" scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// middlewareRequireSession refuses requests made with a personal access
+// token or by an OAuth client, for routes that manage credentials.
+func middlewareRequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := principalFromContext(r.Context()); ok && principal.Delegated() {
+			respondWithError(w, http.StatusForbidden, "This token can only be used from a login session")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (cfg *apiConfig) handlerPersonalTokensCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		// ExpiresInSeconds of zero creates a token that doesn't expire.
+		ExpiresInSeconds int `json:"expires_in_seconds"`
+	}
+
+	userID := requestPrincipal(r).User.ID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+	if params.Name == "" || len(params.Name) > maxPersonalTokenNameLength {
+		respondWithError(w, http.StatusBadRequest, "Invalid name")
+		return
+	}
+	if params.ExpiresInSeconds < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid expiry")
+		return
+	}
+	scopes, ok := normalizeScopes(params.Scopes)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid scopes")
+		return
+	}
+
+	existing, err := cfg.DB.GetPersonalTokens(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token")
+		return
+	}
+	if len(existing) >= maxPersonalTokens {
+		respondWithError(w, http.StatusBadRequest, "Too many personal access tokens")
+		return
+	}
+
+	token, err := auth.MakePersonalToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token")
+		return
+	}
+	record := database.PersonalToken{
+		UserID: userID,
+		Name:   params.Name,
+		Hash:   auth.HashToken(token),
+		Hint:   token[:len(auth.PersonalTokenPrefix)+4],
+		Scopes: scopes,
+	}
+	if params.ExpiresInSeconds > 0 {
+		expiresAt := time.Now().UTC().Add(time.Duration(params.ExpiresInSeconds) * time.Second)
+		record.ExpiresAt = &expiresAt
+	}
+	record, err = cfg.DB.CreatePersonalToken(record)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token")
+		return
+	}
+	cfg.recordAudit(r, userID, "auth.pat_create", userTarget(userID), map[string]string{
+		"token_id": strconv.Itoa(record.ID),
+		"name":     record.Name,
+	})
+
+	response := newPersonalToken(record)
+	response.Token = token
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+// normalizeScopes sorts and deduplicates scopes, reporting false if any is
+// unknown or there are none.
+func normalizeScopes(scopes []string) ([]string, bool) {
+	seen := map[string]struct{}{}
+	normalized := []string{}
+	for _, scope := range scopes {
+		if _, ok := tokenScopes[scope]; !ok {
+			return nil, false
+		}
+		if _, ok := seen[scope]; ok {
+			continue
+		}
+		seen[scope] = struct{}{}
+		normalized = append(normalized, scope)
+	}
+	sort.Strings(normalized)
+	return normalized, len(normalized) > 0
+}
+
+func (cfg *apiConfig) handlerPersonalTokensRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).User.ID
+
+	records, err := cfg.DB.GetPersonalTokens(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch tokens")
+		return
+	}
+
+	tokens := make([]PersonalToken, 0, len(records))
+	for _, record := range records {
+		tokens = append(tokens, newPersonalToken(record))
+	}
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+func (cfg *apiConfig) handlerPersonalTokenDelete(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).User.ID
+
+	tokenID, err := strconv.Atoi(chi.URLParam(r, "tokenID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	err = cfg.DB.DeletePersonalToken(userID, tokenID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Token not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke token")
+		return
+	}
+	cfg.recordAudit(r, userID, "auth.pat_revoke", userTarget(userID), map[string]string{
+		"token_id": strconv.Itoa(tokenID),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"internal/auth"
+	"internal/database"
+)
+
+var (
+	errSuspended   = errors.New("account is suspended")
+	errTokenLogout = errors.New("token was logged out")
+)
+
+// Principal is the user an access token was issued to, loaded once per
+// request by the auth middleware. PersonalToken is set when the request used
+// a personal access token rather than a JWT, in which case Claims is empty.
+// Scopes limit what personal access tokens and OAuth clients may do.
+type Principal struct {
+	User          database.User
+	Claims        auth.Claims
+	PersonalToken *database.PersonalToken
+	Scopes        []string
+}
+
+// Delegated reports whether the request was made by a script or app acting
+// for the user, rather than by the user from a login session.
+func (principal Principal) Delegated() bool {
+	return principal.PersonalToken != nil || principal.Claims.ClientID != ""
+}
+
+// HasScope reports whether the principal may act with scope. Session JWTs
+// carry every scope.
+func (principal Principal) HasScope(scope string) bool {
+	if !principal.Delegated() {
+		return true
+	}
+	for _, s := range principal.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// principalFromContext returns the request's principal, if it was
+// authenticated.
+func principalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// requestPrincipal returns the principal of a request on a route that
+// requires auth.
+func requestPrincipal(r *http.Request) Principal {
+	principal, ok := principalFromContext(r.Context())
+	if !ok {
+		panic("requestPrincipal called on a route without middlewareRequireAuth")
+	}
+	return principal
+}
+
+// requestUserID returns the ID of the user making r, or 0 when it is
+// anonymous. Read paths use it to apply the caller's blocks and mutes.
+func requestUserID(r *http.Request) int {
+	principal, ok := principalFromContext(r.Context())
+	if !ok {
+		return 0
+	}
+	return principal.User.ID
+}
+
+// validateAccessToken checks token like auth.ParseJWT and loads the user it
+// was issued to. It rejects tokens belonging to suspended users, or issued
+// before the user last logged out everywhere, so either takes effect without
+// waiting for their access tokens to expire. Each use of a session's access
+// token is recorded against the session.
+func (cfg *apiConfig) validateAccessToken(token string) (Principal, error) {
+	claims, err := auth.ParseJWT(token, cfg.Keys, cfg.Tokens.Access)
+	if err != nil {
+		return Principal{}, err
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return Principal{}, err
+	}
+	user, err := cfg.DB.GetUserID(userID)
+	if err != nil {
+		return Principal{}, err
+	}
+	principal := Principal{User: user, Claims: claims}
+	if claims.ClientID != "" {
+		principal.Scopes = strings.Fields(claims.Scope)
+	}
+	if user.Suspension.Active(time.Now().UTC()) {
+		return principal, errSuspended
+	}
+	if claims.Generation != user.TokenGeneration {
+		return Principal{}, errTokenLogout
+	}
+	if claims.SessionID != "" {
+		err = cfg.DB.TouchSession(claims.SessionID)
+		if err != nil {
+			log.Printf("Couldn't record use of session %s: %s", claims.SessionID, err)
+		}
+	}
+	return principal, nil
+}
+
+// authenticate validates the bearer token on r, if there is one, and returns
+// r with its principal stored in the context. It writes a 401 or 403 and
+// returns false if the token is present but unusable.
+func (cfg *apiConfig) authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if r.Header.Get("Authorization") == "" {
+		return r, true
+	}
+	token, err := getAuthorization(r)
+	if err != nil {
+		respondUnauthorized(w, "Malformed Authorization header")
+		return nil, false
+	}
+	var principal Principal
+	if strings.HasPrefix(token, auth.PersonalTokenPrefix) {
+		principal, err = cfg.validatePersonalToken(token)
+	} else {
+		principal, err = cfg.validateAccessToken(token)
+	}
+	if errors.Is(err, errSuspended) {
+		respondWithSuspension(w, principal.User.Suspension)
+		return nil, false
+	}
+	if err != nil {
+		respondUnauthorized(w, "Invalid or expired token")
+		return nil, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)), true
+}
+
+// middlewareRequireAuth only lets through requests carrying a valid access
+// token.
+func (cfg *apiConfig) middlewareRequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+		if _, ok := principalFromContext(r.Context()); !ok {
+			respondUnauthorized(w, "Authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// middlewareOptionalAuth authenticates requests that carry an access token
+// and lets anonymous ones through. A bad token is still an error rather than
+// being silently ignored.
+func (cfg *apiConfig) middlewareOptionalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// middlewareForbidAuth guards routes that take other credentials in the
+// Authorization header, such as refresh tokens or API keys, or none at all.
+// Requests presenting an access token or personal access token there are
+// refused.
+func (cfg *apiConfig) middlewareForbidAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, err := getAuthorization(r); err == nil {
+			_, err := auth.ParseJWT(token, cfg.Keys, cfg.Tokens.Access)
+			if err == nil || strings.HasPrefix(token, auth.PersonalTokenPrefix) {
+				respondWithError(w, http.StatusForbidden, "Access tokens aren't accepted here")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// respondUnauthorized writes a 401 with the WWW-Authenticate challenge
+// bearer token clients expect.
+func respondUnauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy"`)
+	respondWithError(w, http.StatusUnauthorized, msg)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"internal/audit"
+	"internal/database"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	permMetricsRead   = "metrics:read"
+	permMetricsReset  = "metrics:reset"
+	permTrendsManage  = "trends:manage"
+	permReportsManage = "reports:manage"
+	permRolesManage   = "roles:manage"
+	permUsersSuspend  = "users:suspend"
+	permUsersManage   = "users:manage"
+	permAuditRead     = "audit:read"
+)
+
+var rolePermissions = map[string]map[string]struct{}{
+	database.RoleUser: {},
+	database.RoleModerator: {
+		permMetricsRead:   {},
+		permTrendsManage:  {},
+		permReportsManage: {},
+		permUsersSuspend:  {},
+	},
+	database.RoleAdmin: {
+		permMetricsRead:   {},
+		permMetricsReset:  {},
+		permTrendsManage:  {},
+		permReportsManage: {},
+		permRolesManage:   {},
+		permUsersSuspend:  {},
+		permUsersManage:   {},
+		permAuditRead:     {},
+	},
+}
+
+// middlewareRequirePermission only lets through session requests from users
+// whose current role is granted permission. The role is read from the user
+// record rather than the token, so demotions take effect immediately.
+// Personal access tokens never carry staff permissions.
+func (cfg *apiConfig) middlewareRequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return cfg.middlewareRequireAuth(middlewareRequireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role := requestPrincipal(r).User.EffectiveRole()
+			if _, ok := rolePermissions[role][permission]; !ok {
+				respondWithError(w, http.StatusForbidden, "You don't have permission to do that")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})))
+	}
+}
+
+func (cfg *apiConfig) handlerUserRoleUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	adminID, userID, ok := cfg.adminUserParams(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+	// Stops the last admin from locking everyone out by accident.
+	if userID == adminID && params.Role != database.RoleAdmin {
+		respondWithError(w, http.StatusBadRequest, "You can't remove your own admin role")
+		return
+	}
+
+	user, err := cfg.DB.SetUserRole(userID, params.Role)
+	if errors.Is(err, database.ErrInvalidRole) {
+		respondWithError(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update role")
+		return
+	}
+	cfg.recordAudit(r, adminID, "user.role_change", userTarget(userID), map[string]string{
+		"role": user.EffectiveRole(),
+	})
+
+	respondWithJSON(w, http.StatusOK, User{
+		ID:           user.ID,
+		EmailID:      user.EmailID,
+		Subscription: user.Subscription,
+		Role:         user.EffectiveRole(),
+	})
+}
+
+// runCreateAdmin gives the account for -email the admin role, creating it
+// first if needed. The password is read from -password or, to keep it out of
+// the process list, from CHIRPY_ADMIN_PASSWORD.
+func runCreateAdmin(db *database.DB, auditLog *audit.Log, args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the admin account")
+	password := flags.String("password", os.Getenv("CHIRPY_ADMIN_PASSWORD"), "password for a new admin account")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *email == "" {
+		return fmt.Errorf("-email is required")
+	}
+
+	user, err := db.GetUser(*email)
+	if err != nil {
+		if *password == "" {
+			return fmt.Errorf("no user %s, and no password given to create one", *email)
+		}
+		hashPass, err := bcrypt.GenerateFromPassword([]byte(*password), 10)
+		if err != nil {
+			return err
+		}
+		user, err = db.CreateUser(*email, hashPass)
+		if err != nil {
+			return err
+		}
+		log.Printf("Created user %s", *email)
+	}
+
+	_, err = db.SetUserRole(user.ID, database.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	_, err = auditLog.Record(audit.Entry{
+		Action: "user.role_change",
+		Target: userTarget(user.ID),
+		Details: map[string]string{
+			"role":   database.RoleAdmin,
+			"source": "create-admin",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("User %d (%s) is now an admin", user.ID, user.EmailID)
+	return nil
+}
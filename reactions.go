@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"internal/database"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Reaction struct {
+	UserID    int       `json:"user_id"`
+	Reaction  string    `json:"reaction"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// allowedReactions is the set of reactions a user may leave on a chirp. A
+// user can hold at most one of each at a time.
+var allowedReactions = map[string]struct{}{
+	"like": {},
+	"👍":    {},
+	"❤️":   {},
+	"😂":    {},
+	"😮":    {},
+	"😢":    {},
+	"🔥":    {},
+}
+
+func (cfg *apiConfig) handlerReactionsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reaction string `json:"reaction"`
+	}
+
+	userID := requestPrincipal(r).User.ID
+
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpsID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+	if _, ok := allowedReactions[params.Reaction]; !ok {
+		respondWithError(w, http.StatusBadRequest, "Unsupported reaction")
+		return
+	}
+
+	reaction, err := cfg.DB.AddReaction(chirpID, userID, params.Reaction)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "No chirp found")
+		return
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		respondWithError(w, http.StatusForbidden, "You can't react to this chirp")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save reaction")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, newReaction(reaction))
+}
+
+func (cfg *apiConfig) handlerReactionsDelete(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).User.ID
+
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpsID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	kind := r.URL.Query().Get("reaction")
+	if _, ok := allowedReactions[kind]; !ok {
+		respondWithError(w, http.StatusBadRequest, "Unsupported reaction")
+		return
+	}
+
+	err = cfg.DB.RemoveReaction(chirpID, userID, kind)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "No chirp found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove reaction")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerReactionsRetrieve(w http.ResponseWriter, r *http.Request) {
+	viewerID := requestUserID(r)
+
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpsID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	dbReactions, err := cfg.DB.GetReactions(chirpID, r.URL.Query().Get("reaction"))
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "No chirp found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch reactions")
+		return
+	}
+
+	hidden := map[int]struct{}{}
+	if viewerID != 0 {
+		hidden, err = cfg.DB.GetBlockedUsers(viewerID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't fetch reactions")
+			return
+		}
+	}
+
+	reactions := []Reaction{}
+	for _, dbReaction := range dbReactions {
+		if _, ok := hidden[dbReaction.UserID]; ok {
+			continue
+		}
+		reactions = append(reactions, newReaction(dbReaction))
+	}
+	sort.Slice(reactions, func(i, j int) bool {
+		return reactions[i].CreatedAt.Before(reactions[j].CreatedAt)
+	})
+
+	respondWithJSON(w, http.StatusOK, reactions)
+}
+
+func newReaction(reaction database.Reaction) Reaction {
+	return Reaction{
+		UserID:    reaction.UserID,
+		Reaction:  reaction.Kind,
+		CreatedAt: reaction.CreatedAt,
+	}
+}
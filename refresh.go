@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"internal/auth"
+	"internal/database"
+)
+
+// newRefreshToken builds the record for a fresh refresh token issued to r,
+// returning the token to hand to the client alongside it.
+func (cfg *apiConfig) newRefreshToken(r *http.Request) (string, database.RefreshToken, error) {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", database.RefreshToken{}, err
+	}
+	return token, database.RefreshToken{
+		Hash:      auth.HashToken(token),
+		ExpiresAt: time.Now().UTC().Add(cfg.Tokens.RefreshLifetime),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}, nil
+}
+
+// newRefreshFamily starts a refresh token family, which is also the login's
+// session, for userID and returns its first token and the family's ID.
+// Families started for an OAuth client carry its ID and granted scopes.
+func (cfg *apiConfig) newRefreshFamily(r *http.Request, userID int, clientID string, scopes []string) (string, string, error) {
+	familyID, err := auth.NewTokenID()
+	if err != nil {
+		return "", "", err
+	}
+	token, record, err := cfg.newRefreshToken(r)
+	if err != nil {
+		return "", "", err
+	}
+	record.UserID = userID
+	record.ClientID = clientID
+	record.Scopes = scopes
+	_, err = cfg.DB.CreateRefreshFamily(familyID, record)
+	if err != nil {
+		return "", "", err
+	}
+	return token, familyID, nil
+}
+
+// accessClaims are the private claims for an access token issued to user
+// from session.
+func accessClaims(user database.User, session string) auth.Claims {
+	return auth.Claims{
+		Role:       user.EffectiveRole(),
+		SessionID:  session,
+		Generation: user.TokenGeneration,
+	}
+}
+
+// rotateRefreshToken exchanges token for the next one in its family.
+func (cfg *apiConfig) rotateRefreshToken(r *http.Request, token string) (string, error) {
+	next, record, err := cfg.newRefreshToken(r)
+	if err != nil {
+		return "", err
+	}
+	old, err := cfg.DB.RotateRefreshToken(auth.HashToken(token), record)
+	if errors.Is(err, database.ErrTokenReused) {
+		cfg.recordAudit(r, old.UserID, "auth.refresh_reuse", userTarget(old.UserID), map[string]string{
+			"family": old.FamilyID,
+		})
+		return "", err
+	}
+	if err != nil {
+		return "", err
+	}
+	return next, nil
+}
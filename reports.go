@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"internal/database"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxReportNoteLength = 500
+
+var reportReasons = map[string]struct{}{
+	"spam":           {},
+	"harassment":     {},
+	"hate":           {},
+	"violence":       {},
+	"self_harm":      {},
+	"misinformation": {},
+	"other":          {},
+}
+
+func (cfg *apiConfig) handlerReportsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason string `json:"reason"`
+		Note   string `json:"note"`
+	}
+
+	userID := requestPrincipal(r).User.ID
+
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpsID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+	if _, ok := reportReasons[params.Reason]; !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid reason")
+		return
+	}
+	if len(params.Note) > maxReportNoteLength {
+		respondWithError(w, http.StatusBadRequest, "Note is too long")
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "No chirp found")
+		return
+	}
+	visible, err := cfg.visibleChirps(userID, []database.Chirp{chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch Chirp")
+		return
+	}
+	if len(visible) == 0 {
+		respondWithError(w, http.StatusNotFound, "No chirp found")
+		return
+	}
+	if chirp.UserID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't report your own chirp")
+		return
+	}
+
+	report, err := cfg.DB.CreateReport(chirpID, userID, params.Reason, params.Note)
+	if errors.Is(err, database.ErrDuplicateReport) {
+		respondWithError(w, http.StatusConflict, "You've already reported this chirp")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create report")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, report)
+}
+
+// handlerReportsRetrieve lists the moderator queue, open reports by default.
+// ?status=claimed, ?status=resolved or ?status=all select other views.
+func (cfg *apiConfig) handlerReportsRetrieve(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = database.ReportStatusOpen
+	case "all":
+		status = ""
+	case database.ReportStatusOpen, database.ReportStatusClaimed, database.ReportStatusResolved:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	reports, err := cfg.DB.GetReports(status)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch reports")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, reports)
+}
+
+func (cfg *apiConfig) handlerReportClaim(w http.ResponseWriter, r *http.Request) {
+	moderatorID, reportID, ok := cfg.reportParams(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := cfg.DB.ClaimReport(reportID, moderatorID)
+	if !respondWithReportError(w, err, "Couldn't claim report") {
+		return
+	}
+	cfg.recordAudit(r, moderatorID, "report.claim", reportTarget(reportID), nil)
+
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+func (cfg *apiConfig) handlerReportResolve(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Resolution string `json:"resolution"`
+		Note       string `json:"note"`
+	}
+
+	moderatorID, reportID, ok := cfg.reportParams(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+	switch params.Resolution {
+	case database.ResolutionDismiss, database.ResolutionHideChirp, database.ResolutionSuspendAuthor:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid resolution")
+		return
+	}
+	if len(params.Note) > maxReportNoteLength {
+		respondWithError(w, http.StatusBadRequest, "Note is too long")
+		return
+	}
+
+	report, err := cfg.DB.ResolveReport(reportID, moderatorID, params.Resolution, params.Note)
+	if !respondWithReportError(w, err, "Couldn't resolve report") {
+		return
+	}
+	cfg.recordAudit(r, moderatorID, "report.resolve", reportTarget(reportID), map[string]string{
+		"resolution": params.Resolution,
+		"chirp_id":   strconv.Itoa(report.ChirpID),
+		"author_id":  strconv.Itoa(report.AuthorID),
+	})
+
+	if params.Resolution == database.ResolutionHideChirp {
+		err = cfg.refreshTrends()
+		if err != nil {
+			log.Printf("Couldn't compute trends: %s", err)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+func reportTarget(reportID int) string {
+	return "report:" + strconv.Itoa(reportID)
+}
+
+// reportParams reads the acting moderator and the report ID from r, writing
+// an error response and returning false if either is invalid.
+func (cfg *apiConfig) reportParams(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	moderatorID := requestPrincipal(r).User.ID
+
+	reportID, err := strconv.Atoi(chi.URLParam(r, "reportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid report ID")
+		return 0, 0, false
+	}
+
+	return moderatorID, reportID, true
+}
+
+// respondWithReportError writes the response for a failed report action and
+// returns false, or returns true if err is nil.
+func respondWithReportError(w http.ResponseWriter, err error, msg string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, database.ErrNotExist):
+		respondWithError(w, http.StatusNotFound, "Report not found")
+	case errors.Is(err, database.ErrReportClaimed):
+		respondWithError(w, http.StatusConflict, "Report is claimed by another moderator")
+	case errors.Is(err, database.ErrReportResolved):
+		respondWithError(w, http.StatusConflict, "Report is already resolved")
+	case errors.Is(err, database.ErrOutranked):
+		respondWithError(w, http.StatusForbidden, "You can only suspend users whose role ranks below yours")
+	default:
+		respondWithError(w, http.StatusInternalServerError, msg)
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"internal/database"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Session is a login as shown to its user: one refresh token family. Apps
+// the user authorized through OAuth show up as sessions with their client ID.
+type Session struct {
+	ID         string    `json:"id"`
+	ClientID   string    `json:"client_id,omitempty"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current,omitempty"`
+}
+
+func newSessions(families []database.RefreshFamily, current string) []Session {
+	sessions := make([]Session, 0, len(families))
+	for _, family := range families {
+		sessions = append(sessions, Session{
+			ID:         family.ID,
+			ClientID:   family.ClientID,
+			UserAgent:  family.UserAgent,
+			IP:         family.IP,
+			CreatedAt:  family.CreatedAt,
+			LastUsedAt: family.LastUsed(),
+			ExpiresAt:  family.ExpiresAt,
+			Current:    family.ID == current,
+		})
+	}
+	return sessions
+}
+
+func (cfg *apiConfig) handlerSessionsRetrieve(w http.ResponseWriter, r *http.Request) {
+	principal := requestPrincipal(r)
+
+	families, err := cfg.DB.GetSessions(principal.User.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch sessions")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newSessions(families, principal.Claims.SessionID))
+}
+
+// handlerSessionDelete logs out one session. Its refresh token stops working
+// at once; access tokens already issued from it last until they expire.
+func (cfg *apiConfig) handlerSessionDelete(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).User.ID
+
+	sessionID := chi.URLParam(r, "sessionID")
+	err := cfg.DB.RevokeSession(userID, sessionID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session")
+		return
+	}
+	cfg.recordAudit(r, userID, "auth.session_revoke", userTarget(userID), map[string]string{
+		"session": sessionID,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerSessionsDelete logs the user out everywhere, including the session
+// making the request: every refresh token is revoked and every access token
+// issued so far is rejected.
+func (cfg *apiConfig) handlerSessionsDelete(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).User.ID
+
+	_, err := cfg.DB.RevokeAllSessions(userID, "logout_all")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions")
+		return
+	}
+	cfg.recordAudit(r, userID, "auth.logout_all", userTarget(userID), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
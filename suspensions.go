@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"internal/database"
+)
+
+// respondWithSuspension tells a suspended user why and for how long.
+func respondWithSuspension(w http.ResponseWriter, suspension *database.Suspension) {
+	type response struct {
+		Error          string     `json:"error"`
+		Reason         string     `json:"reason"`
+		SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
+	}
+	respondWithJSON(w, http.StatusForbidden, response{
+		Error:          "Account suspended",
+		Reason:         suspension.Reason,
+		SuspendedUntil: suspension.Until,
+	})
+}
+
+func (cfg *apiConfig) handlerSuspensionCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason string `json:"reason"`
+		// DurationSeconds of zero suspends the user until reinstated.
+		DurationSeconds int  `json:"duration_seconds"`
+		HideChirps      bool `json:"hide_chirps"`
+	}
+
+	moderatorID, userID, ok := cfg.adminUserParams(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+	if params.Reason == "" {
+		respondWithError(w, http.StatusBadRequest, "A reason is required")
+		return
+	}
+	if params.DurationSeconds < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid duration")
+		return
+	}
+	if userID == moderatorID {
+		respondWithError(w, http.StatusBadRequest, "You can't suspend yourself")
+		return
+	}
+
+	now := time.Now().UTC()
+	suspension := database.Suspension{
+		Reason:      params.Reason,
+		SuspendedBy: moderatorID,
+		SuspendedAt: now,
+		HideChirps:  params.HideChirps,
+	}
+	if params.DurationSeconds > 0 {
+		until := now.Add(time.Duration(params.DurationSeconds) * time.Second)
+		suspension.Until = &until
+	}
+
+	user, err := cfg.DB.SuspendUser(userID, suspension)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if errors.Is(err, database.ErrOutranked) {
+		respondWithError(w, http.StatusForbidden, "You can only suspend users whose role ranks below yours")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't suspend user")
+		return
+	}
+	details := map[string]string{
+		"reason":      suspension.Reason,
+		"hide_chirps": strconv.FormatBool(suspension.HideChirps),
+	}
+	if suspension.Until != nil {
+		details["until"] = suspension.Until.Format(time.RFC3339)
+	}
+	cfg.recordAudit(r, moderatorID, "user.suspend", userTarget(userID), details)
+	cfg.refreshTrendsAfterSuspension(suspension.HideChirps)
+
+	respondWithJSON(w, http.StatusCreated, user.Suspension)
+}
+
+func (cfg *apiConfig) handlerSuspensionDelete(w http.ResponseWriter, r *http.Request) {
+	moderatorID, userID, ok := cfg.adminUserParams(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.DB.GetUserID(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if user.Suspension == nil {
+		respondWithError(w, http.StatusNotFound, "User is not suspended")
+		return
+	}
+
+	_, err = cfg.DB.ReinstateUser(userID, moderatorID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if errors.Is(err, database.ErrOutranked) {
+		respondWithError(w, http.StatusForbidden, "You can only reinstate users whose role ranks below yours")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reinstate user")
+		return
+	}
+	cfg.recordAudit(r, moderatorID, "user.reinstate", userTarget(userID), nil)
+	cfg.refreshTrendsAfterSuspension(user.Suspension.HideChirps)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// refreshTrendsAfterSuspension recomputes trends when a suspension hid or
+// revealed chirps.
+func (cfg *apiConfig) refreshTrendsAfterSuspension(hideChirps bool) {
+	if !hideChirps {
+		return
+	}
+	err := cfg.refreshTrends()
+	if err != nil {
+		log.Printf("Couldn't compute trends: %s", err)
+	}
+}
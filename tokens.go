@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"internal/auth"
+	"internal/database"
+)
+
+// tokenConfig holds how long tokens last and the claims access tokens are
+// issued and checked with. Each lifetime can be set per environment.
+type tokenConfig struct {
+	Access auth.TokenOptions
+	// AccessLifetime is used when the client doesn't ask for one, and
+	// MaxAccessLifetime caps what it may ask for.
+	AccessLifetime    time.Duration
+	MaxAccessLifetime time.Duration
+	RefreshLifetime   time.Duration
+}
+
+// loadTokenConfig reads ACCESS_TOKEN_TTL, ACCESS_TOKEN_MAX_TTL,
+// REFRESH_TOKEN_TTL and JWT_LEEWAY as Go durations, and JWT_AUDIENCE.
+func loadTokenConfig() (tokenConfig, error) {
+	config := tokenConfig{
+		Access: auth.TokenOptions{
+			Issuer:   "chirpy-access",
+			Audience: os.Getenv("JWT_AUDIENCE"),
+			Leeway:   30 * time.Second,
+		},
+		AccessLifetime:    time.Hour,
+		MaxAccessLifetime: 24 * time.Hour,
+		RefreshLifetime:   60 * 24 * time.Hour,
+	}
+	if config.Access.Audience == "" {
+		config.Access.Audience = "chirpy"
+	}
+
+	durations := []struct {
+		env string
+		dst *time.Duration
+	}{
+		{"ACCESS_TOKEN_TTL", &config.AccessLifetime},
+		{"ACCESS_TOKEN_MAX_TTL", &config.MaxAccessLifetime},
+		{"REFRESH_TOKEN_TTL", &config.RefreshLifetime},
+		{"JWT_LEEWAY", &config.Access.Leeway},
+	}
+	for _, d := range durations {
+		value := os.Getenv(d.env)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return tokenConfig{}, fmt.Errorf("invalid %s %q", d.env, value)
+		}
+		*d.dst = parsed
+	}
+	if config.AccessLifetime <= 0 || config.RefreshLifetime <= 0 {
+		return tokenConfig{}, fmt.Errorf("token lifetimes must be positive")
+	}
+	if config.MaxAccessLifetime < config.AccessLifetime {
+		return tokenConfig{}, fmt.Errorf("ACCESS_TOKEN_MAX_TTL is shorter than ACCESS_TOKEN_TTL")
+	}
+
+	return config, nil
+}
+
+// accessLifetime is how long an access token should last when the client
+// asks for expiresInSeconds, zero meaning no preference.
+func (config tokenConfig) accessLifetime(expiresInSeconds int) time.Duration {
+	if expiresInSeconds <= 0 {
+		return config.AccessLifetime
+	}
+	requested := time.Duration(expiresInSeconds) * time.Second
+	if requested > config.MaxAccessLifetime {
+		return config.MaxAccessLifetime
+	}
+	return requested
+}
+
+// makeAccessToken issues an access token for user from session.
+func (cfg *apiConfig) makeAccessToken(user database.User, session string, expiresIn time.Duration) (string, error) {
+	return auth.MakeJWT(user.ID, accessClaims(user, session), cfg.Keys, cfg.Tokens.Access, expiresIn)
+}
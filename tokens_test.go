@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessLifetime(t *testing.T) {
+	config := tokenConfig{
+		AccessLifetime:    time.Hour,
+		MaxAccessLifetime: 24 * time.Hour,
+	}
+
+	tests := []struct {
+		name             string
+		expiresInSeconds int
+		want             time.Duration
+	}{
+		{"zero uses the default", 0, time.Hour},
+		{"negative uses the default", -60, time.Hour},
+		{"within the maximum", 600, 10 * time.Minute},
+		{"exactly the maximum", 24 * 60 * 60, 24 * time.Hour},
+		{"over the maximum is clamped", 7 * 24 * 60 * 60, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := config.accessLifetime(tt.expiresInSeconds); got != tt.want {
+				t.Errorf("accessLifetime(%d) = %v, want %v", tt.expiresInSeconds, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"internal/database"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Trend struct {
+	Tag   string  `json:"tag"`
+	Count int     `json:"count"`
+	Score float64 `json:"score"`
+}
+
+type trendWindow struct {
+	name   string
+	length time.Duration
+}
+
+var trendWindows = []trendWindow{
+	{name: "1h", length: time.Hour},
+	{name: "24h", length: 24 * time.Hour},
+}
+
+const (
+	// trendBaselinePeriods is how many windows before the current one are
+	// used to work out a tag's usual volume.
+	trendBaselinePeriods = 6
+	maxTrends            = 10
+)
+
+// trendCache holds the most recently computed trends for each window. It is
+// filled in by runTrends and read by handlerTrendsRetrieve.
+type trendCache struct {
+	mu         sync.RWMutex
+	computedAt time.Time
+	byWindow   map[string][]Trend
+}
+
+func (tc *trendCache) get(window string) ([]Trend, time.Time, bool) {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	trends, ok := tc.byWindow[window]
+	return trends, tc.computedAt, ok
+}
+
+func (tc *trendCache) set(byWindow map[string][]Trend, computedAt time.Time) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	tc.byWindow = byWindow
+	tc.computedAt = computedAt
+}
+
+// runTrends recomputes trends every interval until the process exits.
+func (cfg *apiConfig) runTrends(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := cfg.refreshTrends()
+		if err != nil {
+			log.Printf("Couldn't compute trends: %s", err)
+		}
+		<-ticker.C
+	}
+}
+
+func (cfg *apiConfig) refreshTrends() error {
+	now := time.Now().UTC()
+	longest := trendWindows[len(trendWindows)-1].length
+
+	chirps, err := cfg.DB.GetChirpsSince(now.Add(-longest * (trendBaselinePeriods + 1)))
+	if err != nil {
+		return err
+	}
+	suppressed, err := cfg.DB.GetSuppressedTags()
+	if err != nil {
+		return err
+	}
+
+	byWindow := map[string][]Trend{}
+	for _, window := range trendWindows {
+		byWindow[window.name] = computeTrends(chirps, suppressed, now, window.length)
+	}
+	cfg.Trends.set(byWindow, now)
+	return nil
+}
+
+// computeTrends scores each tag used in the window ending at now by how far
+// its count sits above its usual volume, as a z-score against the preceding
+// trendBaselinePeriods windows of the same length.
+func computeTrends(chirps []database.Chirp, suppressed map[string]database.TagSuppression, now time.Time, window time.Duration) []Trend {
+	// counts[tag][0] is the current window, counts[tag][n] the n-th one back.
+	counts := map[string][]int{}
+	for _, chirp := range chirps {
+		age := now.Sub(chirp.CreatedAt)
+		if age < 0 {
+			continue
+		}
+		period := int(age / window)
+		if period > trendBaselinePeriods {
+			continue
+		}
+
+		seen := map[string]struct{}{}
+		for _, hashtag := range chirp.Hashtags {
+			if _, ok := seen[hashtag.Tag]; ok {
+				continue
+			}
+			seen[hashtag.Tag] = struct{}{}
+			if _, ok := suppressed[hashtag.Tag]; ok {
+				continue
+			}
+			if counts[hashtag.Tag] == nil {
+				counts[hashtag.Tag] = make([]int, trendBaselinePeriods+1)
+			}
+			counts[hashtag.Tag][period]++
+		}
+	}
+
+	trends := []Trend{}
+	for tag, periods := range counts {
+		current := periods[0]
+		if current == 0 {
+			continue
+		}
+
+		mean := 0.0
+		for _, c := range periods[1:] {
+			mean += float64(c)
+		}
+		mean /= trendBaselinePeriods
+		variance := 0.0
+		for _, c := range periods[1:] {
+			variance += (float64(c) - mean) * (float64(c) - mean)
+		}
+		// Floor the deviation so a tag with a perfectly flat history
+		// doesn't score infinitely high.
+		stddev := math.Max(math.Sqrt(variance/trendBaselinePeriods), 1)
+
+		score := (float64(current) - mean) / stddev
+		if score <= 0 {
+			continue
+		}
+		trends = append(trends, Trend{
+			Tag:   tag,
+			Count: current,
+			Score: math.Round(score*100) / 100,
+		})
+	}
+
+	sort.Slice(trends, func(i, j int) bool {
+		if trends[i].Score != trends[j].Score {
+			return trends[i].Score > trends[j].Score
+		}
+		if trends[i].Count != trends[j].Count {
+			return trends[i].Count > trends[j].Count
+		}
+		return trends[i].Tag < trends[j].Tag
+	})
+	if len(trends) > maxTrends {
+		trends = trends[:maxTrends]
+	}
+	return trends
+}
+
+func (cfg *apiConfig) handlerTrendsRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Window     string    `json:"window"`
+		ComputedAt time.Time `json:"computed_at"`
+		Trends     []Trend   `json:"trends"`
+	}
+
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = trendWindows[0].name
+	}
+
+	trends, computedAt, ok := cfg.Trends.get(window)
+	if !ok {
+		if computedAt.IsZero() {
+			respondWithError(w, http.StatusServiceUnavailable, "Trends not computed yet")
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Unknown trend window")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Window:     window,
+		ComputedAt: computedAt,
+		Trends:     trends,
+	})
+}
+
+func (cfg *apiConfig) handlerTrendSuppressionsRetrieve(w http.ResponseWriter, r *http.Request) {
+	suppressed, err := cfg.DB.GetSuppressedTags()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch suppressed tags")
+		return
+	}
+
+	suppressions := []database.TagSuppression{}
+	for _, suppression := range suppressed {
+		suppressions = append(suppressions, suppression)
+	}
+	sort.Slice(suppressions, func(i, j int) bool {
+		return suppressions[i].Tag < suppressions[j].Tag
+	})
+
+	respondWithJSON(w, http.StatusOK, suppressions)
+}
+
+func (cfg *apiConfig) handlerTrendSuppressionsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tag string `json:"tag"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+
+	tag := strings.ToLower(strings.TrimPrefix(params.Tag, "#"))
+	if tag == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid tag")
+		return
+	}
+
+	suppression, err := cfg.DB.SuppressTag(tag)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't suppress tag")
+		return
+	}
+	actorID := requestPrincipal(r).User.ID
+	cfg.recordAudit(r, actorID, "trends.suppress", "tag:"+tag, nil)
+
+	err = cfg.refreshTrends()
+	if err != nil {
+		log.Printf("Couldn't compute trends: %s", err)
+	}
+
+	respondWithJSON(w, http.StatusCreated, suppression)
+}
+
+func (cfg *apiConfig) handlerTrendSuppressionsDelete(w http.ResponseWriter, r *http.Request) {
+	tag := strings.ToLower(strings.TrimPrefix(chi.URLParam(r, "tag"), "#"))
+
+	err := cfg.DB.UnsuppressTag(tag)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Tag is not suppressed")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unsuppress tag")
+		return
+	}
+	actorID := requestPrincipal(r).User.ID
+	cfg.recordAudit(r, actorID, "trends.unsuppress", "tag:"+tag, nil)
+
+	err = cfg.refreshTrends()
+	if err != nil {
+		log.Printf("Couldn't compute trends: %s", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}